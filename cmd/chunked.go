@@ -0,0 +1,207 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"pgtoch/config"
+	"pgtoch/internal/checkpoint"
+	"pgtoch/internal/etl"
+	"pgtoch/internal/log"
+	"pgtoch/internal/report"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const defaultChunkSize = 100000
+
+func ingestChunked(ctx context.Context, cfg *config.Config, conn etl.Querier, chConn *sql.DB, mode etl.WriteMode, stages []etl.Stage, opts etl.LoadOptions, resume bool, rep *report.Report) error {
+	log := log.StyledLog
+	tr := rep.Table(cfg.Table)
+
+	chunkSize := cfg.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+
+	store, err := checkpoint.NewStore(cfg.StateDir)
+	if err != nil {
+		return fmt.Errorf("failed to open state dir: %w", err)
+	}
+
+	cols, err := etl.ProjectedColumns(ctx, conn, cfg.Table, projection(cfg.Columns, cfg.ExcludeColumns))
+	if err != nil {
+		return fmt.Errorf("failed to read table schema: %w", err)
+	}
+	schemaHash := etl.SchemaFingerprint(cols)
+
+	key, err := etl.PrimaryKey(ctx, conn, cfg.Table)
+	if err != nil {
+		return fmt.Errorf("failed to read primary key: %w", err)
+	}
+	if len(key) == 0 {
+		return fmt.Errorf("%w: chunked ingest requires a primary key on %s", errInvalidConfig, cfg.Table)
+	}
+
+	progress, err := store.LoadProgress(cfg.Table)
+	if err != nil {
+		return fmt.Errorf("failed to load progress: %w", err)
+	}
+
+	var target *etl.Target
+	if resume && progress != nil {
+		if progress.SchemaHash != schemaHash {
+			return fmt.Errorf("%w: postgres schema of %s changed since the interrupted run started at %s; rerun without --resume",
+				errInvalidConfig, cfg.Table, progress.StartedAt.Format(time.RFC3339))
+		}
+		if progress.Mode != string(mode) {
+			return fmt.Errorf("%w: write mode %s differs from the interrupted run (%s); rerun without --resume",
+				errInvalidConfig, mode, progress.Mode)
+		}
+		chunkSize = progress.ChunkSize
+		key = progress.Key
+
+		target, err = etl.ResumeTarget(ctx, chConn, cfg.Target(), mode, clusterOptions(cfg))
+		if err != nil {
+			return fmt.Errorf("failed to resume target table: %w", err)
+		}
+
+		log.Info(fmt.Sprintf("Resuming after %d chunks (%d rows)", progress.ChunksDone, progress.RowsLoaded),
+			zap.String("table", cfg.Table),
+			zap.Strings("last_key", progress.LastKey))
+	} else {
+		if resume {
+			warn(rep, "No unfinished load to resume, starting from the beginning", zap.String("table", cfg.Table))
+		} else if progress != nil {
+			warn(rep, "Discarding progress from an earlier unfinished load", zap.String("table", cfg.Table))
+		}
+
+		ddlOpts, err := ddlOptions(ctx, conn, cfg.Table, mode, cfg.UpsertKeys, cfg.Polling.Deltacol, cfg.Types, clusterOptions(cfg))
+		if err == nil {
+			ddlOpts, err = targetLayout(ddlOpts, stages)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to resolve table layout: %w", err)
+		}
+
+		targetCols, err := etl.StageSchema(cols, stages...)
+		if err != nil {
+			return fmt.Errorf("failed to apply transforms to table schema: %w", err)
+		}
+
+		target, err = etl.PrepareTarget(ctx, chConn, cfg.Target(), targetCols, mode, ddlOpts)
+		if err != nil {
+			return fmt.Errorf("failed to prepare target table: %w", err)
+		}
+		if target.DDL != "" {
+			tr.DDL = append(tr.DDL, target.DDL)
+		}
+
+		progress = &checkpoint.Progress{
+			Table:      cfg.Table,
+			Mode:       string(mode),
+			SchemaHash: schemaHash,
+			Key:        key,
+			ChunkSize:  chunkSize,
+			StartedAt:  time.Now().UTC(),
+		}
+		if err := store.SaveProgress(progress); err != nil {
+			return fmt.Errorf("failed to save progress: %w", err)
+		}
+	}
+
+	for cfg.Limit <= 0 || progress.RowsLoaded < cfg.Limit {
+		size := chunkSize
+		if cfg.Limit > 0 {
+			size = min(size, cfg.Limit-progress.RowsLoaded)
+		}
+
+		var td *etl.TableData
+		err := etl.Retry(ctx, cfg.Table, opts.Retry, func() error {
+			var err error
+			td, err = etl.ExtractChunk(ctx, conn, cfg.Table, cols, key, progress.LastKey, size)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to extract chunk: %w", err)
+		}
+		if len(td.Rows) == 0 {
+			break
+		}
+		tr.RowsRead += len(td.Rows)
+		tr.Bytes += etl.RowBytes(td.Rows)
+
+		stats, err := etl.LoadBatch(ctx, chConn, target.LoadTable, td, stages, opts)
+		tr.RowsWritten += stats.Inserted
+		tr.RowsRejected += stats.Rejected
+		tr.RowsDropped += stats.Dropped
+		if processed := stats.Inserted + stats.Rejected; err != nil && processed > 0 && stats.Dropped == 0 {
+			if lastKey, keyErr := etl.KeyValuesAt(td, key, processed-1); keyErr == nil {
+				progress.LastKey = lastKey
+				progress.RowsLoaded += stats.Inserted
+				if saveErr := store.SaveProgress(progress); saveErr != nil {
+					log.Error("failed to save partial chunk progress", zap.Error(saveErr))
+				}
+			}
+		}
+		if err != nil {
+			var interrupted *etl.InterruptedError
+			if errors.As(err, &interrupted) {
+				warn(rep, "ingestion interrupted; rerun with --resume to continue from the unfinished chunk",
+					zap.String("table", cfg.Table),
+					zap.Int("chunks_done", progress.ChunksDone),
+					zap.Int("rows_loaded", progress.RowsLoaded))
+				return err
+			}
+			return fmt.Errorf("failed to insert chunk; rerun with --resume to retry it: %w", err)
+		}
+
+		lastKey, err := etl.KeyValuesAt(td, key, len(td.Rows)-1)
+		if err != nil {
+			return fmt.Errorf("failed to record chunk position: %w", err)
+		}
+
+		progress.LastKey = lastKey
+		progress.ChunksDone++
+		progress.RowsLoaded += stats.Inserted
+		if err := store.SaveProgress(progress); err != nil {
+			return fmt.Errorf("failed to save progress: %w", err)
+		}
+
+		log.Info(fmt.Sprintf("Chunk %d loaded (%d rows total)", progress.ChunksDone, progress.RowsLoaded),
+			zap.String("table", cfg.Table),
+			zap.Strings("last_key", lastKey))
+
+		if len(td.Rows) < size {
+			break
+		}
+	}
+
+	if err := target.Finalize(ctx, chConn, progress.RowsLoaded); err != nil {
+		return fmt.Errorf("failed to swap staging table, live table left untouched: %w", err)
+	}
+
+	if err := store.ClearProgress(cfg.Table); err != nil {
+		warn(rep, "failed to clear progress file", zap.Error(err))
+	}
+
+	log.Success("chunked ingestion complete",
+		zap.String("table", cfg.Table),
+		zap.Int("chunks", progress.ChunksDone),
+		zap.Int("rows", progress.RowsLoaded))
+
+	if tr.RowsRejected > 0 {
+		warn(rep, fmt.Sprintf("%d rows rejected and sent to the dead-letter sink", tr.RowsRejected),
+			zap.String("table", cfg.Table),
+			zap.Int("rejected", tr.RowsRejected))
+	}
+	if tr.RowsDropped > 0 {
+		warn(rep, fmt.Sprintf("%d rows held back by data quality rules", tr.RowsDropped),
+			zap.String("table", cfg.Table),
+			zap.Int("dropped", tr.RowsDropped))
+	}
+
+	return nil
+}
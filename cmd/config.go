@@ -0,0 +1,188 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"pgtoch/config"
+	ui "pgtoch/internal/UI"
+	"pgtoch/internal/etl"
+	"pgtoch/internal/log"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var configValidatePath string
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Work with pgtoch config files",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate [file]",
+	Short: "Check a config file without connecting to any database",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ui.PrintTitle("Config Validation")
+		ui.PrintSubtitle("checking the config file schema and values")
+
+		log := log.StyledLog
+
+		path := configValidatePath
+		if len(args) == 1 {
+			path = args[0]
+		}
+		if path == "" {
+			path = ".pgtoch.yaml"
+		}
+
+		cfg, err := config.LoadConfig(path)
+		if err == nil {
+			cfg.ApplyDefaults()
+			err = validateConfig(cfg, false)
+		}
+		if err == nil {
+			err = checkStages(cfg)
+		}
+		if err != nil {
+			log.Error("Config is invalid", zap.String("file", path))
+			ui.PrintBox("Problems", err.Error())
+			setExitCode(exitConfig)
+			return
+		}
+
+		var summary []string
+		summary = append(summary, fmt.Sprintf("Version: %d", cfg.Version))
+		if len(cfg.Sources) > 0 || len(cfg.Destinations) > 0 {
+			summary = append(summary, fmt.Sprintf("Sources: %d, Destinations: %d", len(cfg.Sources), len(cfg.Destinations)))
+		}
+		if cfg.Table != "" {
+			summary = append(summary, fmt.Sprintf("Table: %s -> %s (%s, batch size %d)", cfg.Table, cfg.Target(), cfg.Mode, cfg.BatchSize))
+		}
+		for _, p := range cfg.Pipelines {
+			summary = append(summary, fmt.Sprintf("Pipeline %s: %s -> %s (%s, batch size %d)", p.Name, p.Table, p.Target(), p.Mode, p.BatchSize))
+		}
+		log.Success("Config is valid", zap.String("file", path))
+		ui.PrintBox("Config", strings.Join(summary, "\n"))
+	},
+}
+
+// readConfig loads the config file for a command. A missing default file is
+// not an error, as every value can also come from flags; an explicit --config
+// that does not exist is.
+func readConfig(path string) (*config.Config, error) {
+	cfg, err := config.LoadConfig(path)
+	if errors.Is(err, config.ErrNotFound) && path == "" {
+		return &config.Config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidConfig, err)
+	}
+	return cfg, nil
+}
+
+// selectPipeline narrows cfg to the pipeline named with --pipeline, if any.
+func selectPipeline(cfg *config.Config, name string) (*config.Config, error) {
+	if name == "" {
+		return cfg, nil
+	}
+	cfg.ApplyDefaults()
+	pc, err := cfg.Pipeline(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidConfig, err)
+	}
+	return pc, nil
+}
+
+// validateConfig runs cfg.Validate, or cfg.ValidateSync for sync, and the
+// checks that need the etl package: the write modes and the delta column
+// against the selected columns. sync polls every pipeline and loads it in
+// append or upsert mode only.
+func validateConfig(cfg *config.Config, sync bool) error {
+	validate := cfg.Validate
+	if sync {
+		validate = cfg.ValidateSync
+	}
+	var errs config.Errors
+	if err := validate(); err != nil {
+		errs = append(errs, err.(config.Errors)...)
+	}
+
+	check := func(prefix, mode string, polling config.PollingConfig, columns, exclude []string, syncing bool) {
+		key := func(k string) string {
+			if prefix == "" {
+				return k
+			}
+			return prefix + "." + k
+		}
+		m, err := etl.ParseWriteMode(mode)
+		switch {
+		case err != nil:
+			errs = append(errs, cfg.ErrorAt(key("mode"), err.Error()))
+		case m == etl.ModeReplace && cfg.Cluster.Name != "":
+			errs = append(errs, cfg.ErrorAt(key("mode"), "replace is not supported with cluster; use truncate"))
+		case syncing && m != etl.ModeAppend && m != etl.ModeUpsert:
+			errs = append(errs, cfg.ErrorAt(key("mode"), "sync pipelines support only append or upsert mode"))
+		}
+		if (polling.Enabled || syncing) && polling.Deltacol != "" && !projection(columns, exclude).Includes(polling.Deltacol) {
+			errs = append(errs, cfg.ErrorAt(key("polling.delta_column"), fmt.Sprintf("column %s must be part of the selected columns", polling.Deltacol)))
+		}
+	}
+	if cfg.Table != "" {
+		check("", cfg.Mode, cfg.Polling, cfg.Columns, cfg.ExcludeColumns, false)
+	}
+	for i, p := range cfg.Pipelines {
+		if p.Table != "" {
+			check(fmt.Sprintf("pipelines[%d]", i), p.Mode, p.Polling, p.Columns, p.ExcludeColumns, sync)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// checkStages builds the quality rules and transforms of every table so that
+// their errors show up without running a load. Quarantine rules only need a
+// sink to exist, so a stand-in is used when one is configured.
+func checkStages(cfg *config.Config) error {
+	var sink etl.DeadLetterSink
+	if cfg.DeadLetter.Type != "" || cfg.DeadLetter.Path != "" {
+		sink = discardSink{}
+	}
+
+	var msgs []string
+	check := func(table string, rules []config.QualityRule, tc config.TransformConfig) {
+		if _, _, err := batchStages(table, rules, tc, sink); err != nil {
+			msgs = append(msgs, fmt.Sprintf("%s: %v", table, err))
+		}
+	}
+	if cfg.Table != "" {
+		check(cfg.Table, cfg.Quality, cfg.Transform)
+	}
+	for _, p := range cfg.Pipelines {
+		check(p.Table, p.Quality, p.Transform)
+	}
+
+	if len(msgs) > 0 {
+		return errors.New(strings.Join(msgs, "\n"))
+	}
+	return nil
+}
+
+// discardSink stands in for the dead-letter sink when nothing is loaded.
+type discardSink struct{}
+
+func (discardSink) Write(context.Context, string, []string, []etl.RejectedRow) error {
+	return nil
+}
+
+func init() {
+	configValidateCmd.Flags().StringVar(&configValidatePath, "config", "", "Path to YAML config file (default: .pgtoch.yaml)")
+	configCmd.AddCommand(configValidateCmd)
+	rootCmd.AddCommand(configCmd)
+}
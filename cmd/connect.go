@@ -1,7 +1,6 @@
 package cmd
 
 import (
-	"context"
 	ui "pgtoch/internal/UI"
 	"pgtoch/internal/db"
 	"pgtoch/internal/log"
@@ -21,22 +20,25 @@ var connectCmd = &cobra.Command{
 		ui.PrintTitle("Testing db connections")
 		ui.PrintSubtitle("checking connectivity to postgres and clickhouse")
 
+		ctx := cmd.Context()
 		log := log.StyledLog
 
 		log.Info("Testing postgres connection")
-		conn, err := db.ConnectPostgres(pgURL)
+		conn, err := db.ConnectPostgres(ctx, pgURL, db.PostgresOptions{ReadOnly: true})
 		if err != nil {
 			log.Error("Postgres connection failed", zap.Error(err))
+			setExitCode(exitFailure)
 			return
 		}
 
-		defer conn.Close(context.Background())
+		defer conn.Close()
 		log.Success("Postgres connection successful")
 
 		log.Info("Testing clickhouse connection")
-		chConn, err := db.ConnectClickhouse(chURL)
+		chConn, err := db.ConnectClickhouse(ctx, chURL, db.ClickHouseOptions{})
 		if err != nil {
 			log.Error("Clickhouse connection failed", zap.Error(err))
+			setExitCode(exitFailure)
 			return
 		}
 
@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"pgtoch/config"
+	ui "pgtoch/internal/UI"
+	"pgtoch/internal/db"
+	"pgtoch/internal/etl"
+	"pgtoch/internal/events"
+	"pgtoch/internal/report"
+	"slices"
+	"sync"
+)
+
+// defaultConnection stands for pg_url or ch_url among the named sources and
+// destinations.
+const defaultConnection = "default"
+
+// consoleBackend runs the interactive console against the config file. A
+// table is loaded with the settings of the pipeline reading it, or with the
+// top-level settings when there is none.
+type consoleBackend struct {
+	cfg *config.Config
+
+	mu    sync.Mutex
+	pools map[string]*db.Postgres // by source
+}
+
+func newConsoleBackend(path string) (*consoleBackend, error) {
+	cfg, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ApplyDefaults()
+	return &consoleBackend{cfg: cfg, pools: make(map[string]*db.Postgres)}, nil
+}
+
+func (b *consoleBackend) Connections() []ui.Connection {
+	var sources, destinations []string
+	if b.cfg.PostgreSQLURL != "" || config.PostgresFromEnv() {
+		sources = append(sources, defaultConnection)
+	}
+	if b.cfg.ClickHouseURL != "" {
+		destinations = append(destinations, defaultConnection)
+	}
+	sources = append(sources, slices.Sorted(maps.Keys(b.cfg.Sources))...)
+	destinations = append(destinations, slices.Sorted(maps.Keys(b.cfg.Destinations))...)
+
+	var conns []ui.Connection
+	for _, src := range slices.Compact(sources) {
+		for _, dst := range slices.Compact(destinations) {
+			conns = append(conns, ui.Connection{Source: src, Destination: dst})
+		}
+	}
+	return conns
+}
+
+func (b *consoleBackend) Tables(ctx context.Context, conn ui.Connection) ([]ui.Table, error) {
+	pg, err := b.postgres(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := etl.ListTables(ctx, pg)
+	if err != nil {
+		return nil, err
+	}
+	tables := make([]ui.Table, len(infos))
+	for i, t := range infos {
+		tables[i] = ui.Table{Name: t.Name, RowEstimate: t.RowEstimate}
+	}
+	return tables, nil
+}
+
+func (b *consoleBackend) Schema(ctx context.Context, conn ui.Connection, table string) ([]ui.SchemaColumn, error) {
+	cfg, err := b.config(conn, table)
+	if err != nil {
+		return nil, err
+	}
+	pg, err := b.postgres(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	cols, err := etl.ProjectedColumns(ctx, pg, table, projection(cfg.Columns, cfg.ExcludeColumns))
+	if err != nil {
+		return nil, err
+	}
+	schema := make([]ui.SchemaColumn, len(cols))
+	for i, col := range cols {
+		chType, _ := etl.ColumnType(col, cfg.Types)
+		schema[i] = ui.SchemaColumn{Name: col.Name, PostgresType: col.Type, ClickHouseType: chType}
+	}
+	return schema, nil
+}
+
+func (b *consoleBackend) Ingest(ctx context.Context, conn ui.Connection, table string) error {
+	cfg, err := b.config(conn, table)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errInvalidConfig, err)
+	}
+	if err := validateConfig(cfg, false); err != nil {
+		return fmt.Errorf("%w: %w", errInvalidConfig, err)
+	}
+
+	pg, err := b.postgres(ctx, conn)
+	if err != nil {
+		return err
+	}
+	estimate, err := etl.EstimateRows(ctx, pg, table)
+	if err != nil {
+		return err
+	}
+	total := max(int(estimate), 0)
+	if cfg.Limit > 0 && (total == 0 || cfg.Limit < total) {
+		total = cfg.Limit
+	}
+	events.Publish(ctx, table, events.Event{Kind: events.Started, Total: total})
+
+	return ingest(ctx, cfg, report.New("ingest"))
+}
+
+// config returns the settings table is loaded with over conn.
+func (b *consoleBackend) config(conn ui.Connection, table string) (*config.Config, error) {
+	for _, p := range b.cfg.Pipelines {
+		source := p.Source
+		if source == "" {
+			source = defaultConnection
+		}
+		if p.Table == table && source == conn.Source {
+			pc, err := b.cfg.Pipeline(p.Name)
+			if err != nil {
+				return nil, err
+			}
+			// the connection picked wins over the pipeline's
+			return b.connection(*pc, conn), nil
+		}
+	}
+
+	cfg := b.connection(*b.cfg, conn)
+	cfg.Pipelines = nil
+	if table != b.cfg.Table {
+		// the top-level table settings belong to another table
+		cfg.Table, cfg.TargetTable = table, ""
+		cfg.Columns, cfg.ExcludeColumns, cfg.Types = nil, nil, nil
+		cfg.Mode, cfg.UpsertKeys = "", nil
+		cfg.Polling = config.PollingConfig{}
+		cfg.Quality, cfg.Transform = nil, config.TransformConfig{}
+		cfg.ApplyDefaults()
+	}
+	return cfg, nil
+}
+
+// connection points cfg at the source and destination of conn.
+func (b *consoleBackend) connection(cfg config.Config, conn ui.Connection) *config.Config {
+	if src, ok := b.cfg.Sources[conn.Source]; ok {
+		cfg.PostgreSQLURL, cfg.PostgreSQLPassword = src.URL, src.Password
+		cfg.PostgreSQLReplicaURL = src.ReplicaURL
+	} else {
+		cfg.PostgreSQLURL, cfg.PostgreSQLPassword = b.cfg.PostgreSQLURL, b.cfg.PostgreSQLPassword
+		cfg.PostgreSQLReplicaURL = b.cfg.PostgreSQLReplicaURL
+	}
+	if dst, ok := b.cfg.Destinations[conn.Destination]; ok {
+		cfg.ClickHouseURL, cfg.ClickHousePassword = dst.URL, dst.Password
+	} else {
+		cfg.ClickHouseURL, cfg.ClickHousePassword = b.cfg.ClickHouseURL, b.cfg.ClickHousePassword
+	}
+	return &cfg
+}
+
+// postgres returns the pool of the source of conn, connecting on first use.
+func (b *consoleBackend) postgres(ctx context.Context, conn ui.Connection) (*db.Postgres, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if pg, ok := b.pools[conn.Source]; ok {
+		return pg, nil
+	}
+
+	cfg := b.connection(*b.cfg, conn)
+	pg, err := db.ConnectPostgres(ctx, cfg.PostgresDSN(), postgresOptions(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+	}
+	b.pools[conn.Source] = pg
+	return pg, nil
+}
+
+func (b *consoleBackend) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, pg := range b.pools {
+		pg.Close()
+	}
+}
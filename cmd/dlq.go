@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"fmt"
+	ui "pgtoch/internal/UI"
+	"pgtoch/internal/db"
+	"pgtoch/internal/dlq"
+	"pgtoch/internal/etl"
+	"pgtoch/internal/log"
+	"slices"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var (
+	dlqConfigPath, dlqChURL, dlqFile, dlqTable, dlqRemaining string
+	dlqFromClickHouse                                        bool
+	dlqBatch                                                 int
+)
+
+var dlqCmd = &cobra.Command{
+	Use:   "dlq",
+	Short: "Inspect and replay dead-lettered rows",
+}
+
+var dlqReplayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Re-insert dead-lettered rows into clickhouse",
+	Run: func(cmd *cobra.Command, args []string) {
+		ui.PrintTitle("Dead-letter Replay")
+		ui.PrintSubtitle("re-attempting rejected rows")
+
+		ctx := cmd.Context()
+		log := log.StyledLog
+
+		cfg, err := readConfig(dlqConfigPath)
+		if err != nil {
+			log.Error("Failed to load config", zap.Error(err))
+			setExitCode(exitConfig)
+			return
+		}
+		if dlqChURL != "" {
+			cfg.ClickHouseURL = dlqChURL
+		}
+		if cfg.ClickHouseURL == "" {
+			log.Error("Missing ClickHouse URL. Provide it in YAML or with --ch-url.")
+			setExitCode(exitConfig)
+			return
+		}
+		if dlqFile == "" && !dlqFromClickHouse {
+			dlqFile = cfg.DeadLetter.Path
+		}
+		if dlqFile == "" && !dlqFromClickHouse {
+			log.Error("Nothing to replay. Provide --file or --from-clickhouse.")
+			setExitCode(exitConfig)
+			return
+		}
+
+		chConn, err := db.ConnectClickhouse(ctx, cfg.ClickHouseDSN(), clickhouseOptions(cfg))
+		if err != nil {
+			log.Error("Failed to connect to ClickHouse", zap.Error(err))
+			setExitCode(exitFailure)
+			return
+		}
+		defer chConn.Close()
+
+		// fileRecords are rewritten without the rows handled by the replay, so
+		// that running it again does not insert them twice
+		var records, fileRecords []dlq.Record
+		source := dlqFile
+		if dlqFromClickHouse {
+			source = dlq.TableName
+			records, err = dlq.ReadTable(ctx, chConn, dlqTable)
+		} else {
+			fileRecords, err = dlq.ReadFile(dlqFile)
+			records = slices.Clone(fileRecords)
+			for i := range records {
+				records[i].ID = strconv.Itoa(i)
+			}
+		}
+		if err != nil {
+			log.Error("Failed to read dead-letter records", zap.Error(err))
+			setExitCode(exitFailure)
+			return
+		}
+
+		if dlqTable != "" && !dlqFromClickHouse {
+			filtered := records[:0]
+			for _, r := range records {
+				if r.Table == dlqTable {
+					filtered = append(filtered, r)
+				}
+			}
+			records = filtered
+		}
+
+		if len(records) == 0 {
+			log.Success("No dead-lettered rows to replay")
+			return
+		}
+
+		// rows from the dead-letter table stay in it when they fail again
+		var sink etl.DeadLetterSink
+		remaining := dlq.TableName
+		if !dlqFromClickHouse {
+			remaining = dlqRemaining
+			if remaining == "" {
+				remaining = dlqFile + ".remaining"
+			}
+			sink = dlq.NewFileSink(remaining)
+		}
+
+		opts := loadOptions(cfg, dlqBatch, sink)
+		opts.MaxErrors = -1
+
+		ui.PrintBox("Replay",
+			"Source: "+source+"\n"+
+				"Records: "+fmt.Sprintf("%d", len(records))+"\n"+
+				"Still failing rows go to: "+remaining)
+
+		handled := make(map[string]bool)
+		if !dlqFromClickHouse {
+			defer func() {
+				if len(handled) == 0 {
+					return
+				}
+				var left []dlq.Record
+				for i, r := range fileRecords {
+					if !handled[strconv.Itoa(i)] {
+						left = append(left, r)
+					}
+				}
+				if err := dlq.RewriteFile(dlqFile, left); err != nil {
+					log.Error("Failed to remove replayed rows from the dead-letter file", zap.String("file", dlqFile), zap.Error(err))
+					setExitCode(exitFailure)
+					return
+				}
+				log.Info(fmt.Sprintf("Removed %d handled rows from %s", len(handled), dlqFile))
+			}()
+		}
+
+		var replayed, failed int
+		for _, group := range dlq.GroupByTable(records) {
+			result, err := dlq.Replay(ctx, chConn, group, opts)
+			replayed += result.Replayed
+			failed += result.Failed
+			for _, id := range result.ReplayedIDs {
+				handled[id] = true
+			}
+			for _, r := range result.Rejected {
+				handled[r.ID] = true
+			}
+
+			if dlqFromClickHouse {
+				if err := dlq.DeleteFromTable(ctx, chConn, result.ReplayedIDs); err != nil {
+					log.Warn("Replayed rows could not be removed from the dead-letter table", zap.Error(err))
+				}
+				if err := dlq.UpdateErrors(ctx, chConn, result.Rejected); err != nil {
+					log.Warn("Errors of rows still failing could not be updated", zap.Error(err))
+				}
+			}
+			if err != nil {
+				log.Error("Replay failed", zap.String("table", result.Table), zap.Error(err))
+				setExitCode(exitFailure)
+				return
+			}
+			log.Info(fmt.Sprintf("Replayed %d rows into %s", result.Replayed, result.Table),
+				zap.String("table", result.Table),
+				zap.Int("replayed", result.Replayed),
+				zap.Int("failed", result.Failed))
+		}
+
+		if failed > 0 {
+			log.Warn(fmt.Sprintf("%d rows still failing, left in %s", failed, remaining))
+			setExitCode(exitFailure)
+		}
+		log.Success("Replay complete", zap.Int("replayed", replayed), zap.Int("failed", failed))
+	},
+}
+
+func init() {
+	dlqReplayCmd.Flags().StringVar(&dlqConfigPath, "config", "", "Path to YAML config file (default: .pgtoch.yaml)")
+	dlqReplayCmd.Flags().StringVar(&dlqChURL, "ch-url", "", "ClickHouse connection URL")
+	dlqReplayCmd.Flags().StringVar(&dlqFile, "file", "", "Dead-letter JSONL file to replay")
+	dlqReplayCmd.Flags().BoolVar(&dlqFromClickHouse, "from-clickhouse", false, "Replay rows stored in the "+dlq.TableName+" table")
+	dlqReplayCmd.Flags().StringVar(&dlqTable, "table", "", "Only replay rows for this table")
+	dlqReplayCmd.Flags().StringVar(&dlqRemaining, "remaining", "", "File for rows from --file that still fail (default: <file>.remaining)")
+	dlqReplayCmd.Flags().IntVar(&dlqBatch, "batch-size", 500, "Rows per ClickHouse insert")
+	dlqCmd.AddCommand(dlqReplayCmd)
+	rootCmd.AddCommand(dlqCmd)
+}
@@ -0,0 +1,20 @@
+package cmd
+
+// Process exit codes. They are part of the CLI contract and documented in
+// the README; when several apply the highest wins.
+const (
+	exitOK          = 0
+	exitFailure     = 1   // any other error: connection, query, load
+	exitDrift       = 2   // validate found differences
+	exitConfig      = 3   // invalid flags or configuration
+	exitRejected    = 4   // rows rejected beyond max_errors or a quality rule with action fail
+	exitInterrupted = 130 // stopped by SIGINT/SIGTERM
+)
+
+var exitCode = exitOK
+
+func setExitCode(code int) {
+	if code > exitCode {
+		exitCode = code
+	}
+}
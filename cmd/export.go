@@ -1,76 +1,93 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"path/filepath"
-	"pgtoch/config"
 	ui "pgtoch/internal/UI"
 	"pgtoch/internal/etl/export"
 	"pgtoch/internal/log"
+	"pgtoch/internal/report"
 
 	"github.com/spf13/cobra"
-	"go.uber.org/zap"
 )
 
 var (
-	exportTable, exportFormat, exportOut, exportChurl, exportConfigPath string
+	exportTable, exportFormat, exportOut, exportChurl, exportConfigPath, exportOutput, exportReportPath, exportPipeline string
 )
 
 var exportCmd = &cobra.Command{
 	Use:   "export",
 	Short: "Export from clickhouse ==> csv",
 	Run: func(cmd *cobra.Command, args []string) {
+		if exportOutput == "json" {
+			ui.Output = os.Stderr
+		}
 		ui.PrintTitle("Exporting data")
 		ui.PrintSubtitle("clickhouse table to csv")
 
-		log := log.StyledLog
-		log.Info("Starting export..")
-
-		cfg, err := config.LoadConfig(exportConfigPath)
-		if err != nil {
-			log.Error("Failed to load config falling to def", zap.Error(err))
-			cfg = &config.Config{
-				ClickHouseURL: exportChurl,
-				Table:         exportTable,
-			}
-		} else {
-			if exportChurl != "" {
-				cfg.ClickHouseURL = exportChurl
-			}
-			if exportTable != "" {
-				cfg.Table = exportTable
-			}
-		}
-
-		if cfg.ClickHouseURL == "" || cfg.Table == "" {
-			log.Fatal("missing required config values", zap.String("clickhouse_url", cfg.ClickHouseURL), zap.String("table", cfg.Table))
-			return
-		}
-
-		ui.PrintBox("Export Details", fmt.Sprintf("Table: %s\nFormat: %s\nOutput: %s", cfg.Table, exportFormat, exportOut))
-
-		outPath := filepath.Join(exportOut, fmt.Sprintf("%s.%s", cfg.Table, exportFormat))
-
-		log.Info("Starting extraction")
-
-		if err := export.ExportTabletoCSV(cfg.ClickHouseURL, cfg.Table, outPath); err != nil {
-			log.Error("Failed to export ", zap.Error(err), zap.String("table", cfg.Table), zap.String("format", exportFormat), zap.String("output", outPath))
-			return
-		}
-
-		log.Success("Export completed successfully")
-		ui.PrintBox("Export Complete", fmt.Sprintf("table %s format %s output file : %s", cfg.Table, exportFormat, outPath))
-
+		rep := report.New("export")
+		err := runExport(cmd.Context(), rep)
+		finishRun(cmd.Context(), rep, err, exportReportPath, exportOutput)
 	},
 }
 
+func runExport(ctx context.Context, rep *report.Report) error {
+	log := log.StyledLog
+	log.Info("Starting export..")
+
+	cfg, err := readConfig(exportConfigPath)
+	if err == nil {
+		cfg, err = selectPipeline(cfg, exportPipeline)
+	}
+	if err != nil {
+		return err
+	}
+	if exportChurl != "" {
+		cfg.ClickHouseURL = exportChurl
+	}
+	if exportTable != "" {
+		cfg.Table, cfg.TargetTable = exportTable, ""
+	}
+
+	if cfg.ClickHouseURL == "" || cfg.Target() == "" {
+		return fmt.Errorf("%w: missing ch_url or table. Provide them in YAML or as flags", errInvalidConfig)
+	}
+
+	ui.PrintBox("Export Details", fmt.Sprintf("Table: %s\nFormat: %s\nOutput: %s", cfg.Target(), exportFormat, exportOut))
+
+	outPath := filepath.Join(exportOut, fmt.Sprintf("%s.%s", cfg.Target(), exportFormat))
+	tr := rep.Table(cfg.Target())
+	tr.Output = outPath
+
+	log.Info("Starting extraction")
+
+	count, err := export.ExportTabletoCSV(ctx, cfg.ClickHouseDSN(), clickhouseOptions(cfg), cfg.Target(), outPath)
+	tr.RowsRead, tr.RowsWritten = count, count
+	if info, statErr := os.Stat(outPath); statErr == nil {
+		tr.Bytes = info.Size()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to export %s to %s: %w", cfg.Target(), outPath, err)
+	}
+
+	log.Success("Export completed successfully")
+	ui.PrintBox("Export Complete", fmt.Sprintf("table %s format %s output file : %s", cfg.Target(), exportFormat, outPath))
+	return nil
+}
+
 func init() {
 	exportCmd.Flags().StringVar(&exportConfigPath, "config", "", "Path to YAML config file")
 	exportCmd.Flags().StringVar(&exportChurl, "ch-url", "", "ClickHouse connection URL")
 	exportCmd.Flags().StringVar(&exportTable, "table", "", "Table name to export")
+	exportCmd.Flags().StringVar(&exportPipeline, "pipeline", "", "Export the target table of the pipeline with this name from the config")
 	exportCmd.Flags().StringVar(&exportFormat, "format", "csv", "Export format (csv)")
 	exportCmd.Flags().StringVar(&exportOut, "out", ".", "Output directory for exported files")
 
+	exportCmd.Flags().StringVar(&exportReportPath, "report", "", "Write a JSON run report to this file")
+	exportCmd.Flags().StringVar(&exportOutput, "output", "text", "Output format: text, or json to print the run report on stdout")
+
 	exportCmd.MarkFlagRequired("format")
 	exportCmd.MarkFlagRequired("out")
 
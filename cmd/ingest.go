@@ -2,205 +2,348 @@ package cmd
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"os"
 	"pgtoch/config"
 	ui "pgtoch/internal/UI"
+	"pgtoch/internal/checkpoint"
 	"pgtoch/internal/db"
 	"pgtoch/internal/etl"
+	"pgtoch/internal/events"
 	"pgtoch/internal/log"
+	"pgtoch/internal/report"
 
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 )
 
 var (
-	ingestPgURL, ingestChURL, ingestTable, ingestConfigPath, ingestPollDelta string
-	ingestLimit, ingestBatch, ingestPollInt                                  int
-	ingestPoll                                                               bool
+	ingestPgURL, ingestChURL, ingestTable, ingestConfigPath, ingestPollDelta, ingestDLQFile, ingestMode string
+	ingestLimit, ingestBatch, ingestPollInt, ingestPollMaxFailures, ingestMaxErrors, ingestChunkSize    int
+	ingestOutput, ingestReportPath, ingestPipeline                                                      string
+	ingestPoll, ingestResume                                                                            bool
+	ingestUpsertKeys, ingestColumns, ingestExcludeColumns                                               []string
 )
 
 var ingestCmd = &cobra.Command{
-	Use:   "data ingest",
-	Short: "transeferring postgres to clickhouse",
+	Use:     "data ingest",
+	Aliases: []string{"ingest"},
+	Short:   "transeferring postgres to clickhouse",
 	Run: func(cmd *cobra.Command, args []string) {
+		if ingestOutput == "json" {
+			ui.Output = os.Stderr
+		}
 		ui.PrintTitle("Data Ingestion")
 		ui.PrintSubtitle("transferring postgres to clickhouse")
 
-		ctx := context.Background()
-		log := log.StyledLog
-		log.Info("Starting data ingestion..")
+		rep := report.New("ingest")
+		err := runIngest(cmd.Context(), rep)
+		finishRun(cmd.Context(), rep, err, ingestReportPath, ingestOutput)
+	},
+}
 
-		cfg := loadConfig()
+func runIngest(ctx context.Context, rep *report.Report) error {
+	log.StyledLog.Info("Starting data ingestion..")
 
-		if !validateConfig(cfg) {
-			return
-		}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	return ingest(ctx, cfg, rep)
+}
 
-		ui.PrintBox("Configuration",
-			"PostgreSQL: Connected\n"+
-				"ClickHouse: Connected\n"+
-				"Target Table: "+cfg.Table+"\n"+
-				"Batch Size: "+ui.HighlightStyle.Render(UI_itoa(cfg.BatchSize))+" rows\n"+
-				"Limit: "+ui.HighlightStyle.Render(UI_itoa(cfg.Limit))+" rows")
+// ingest loads cfg.Table and keeps polling it when polling is enabled.
+func ingest(ctx context.Context, cfg *config.Config, rep *report.Report) error {
+	log := log.StyledLog
 
-		conn, err := db.ConnectPostgres(cfg.PostgreSQLURL)
-		if err != nil {
-			log.Error("Failed to connect to PostgreSQL", zap.Error(err))
-			return
-		}
-		defer conn.Close(ctx)
+	mode, err := etl.ParseWriteMode(cfg.Mode)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errInvalidConfig, err)
+	}
 
-		log.Info("extracting table data")
+	ctx = events.WithPipeline(ctx, cfg.Table)
+	tr := rep.Table(cfg.Table)
 
-		td, err := etl.ExtractTableData(ctx, conn, cfg.Table, &cfg.Limit)
+	ui.PrintBox("Configuration",
+		"PostgreSQL: Connected\n"+
+			"ClickHouse: Connected\n"+
+			"Target Table: "+cfg.Target()+"\n"+
+			"Mode: "+ui.HighlightStyle.Render(string(mode))+" ("+mode.Describe()+")\n"+
+			"Batch Size: "+ui.HighlightStyle.Render(UI_itoa(cfg.BatchSize))+" rows\n"+
+			"Limit: "+ui.HighlightStyle.Render(UI_itoa(cfg.Limit))+" rows")
 
-		if err != nil {
-			log.Error("failed to extract data from table", zap.Error(err))
-			return
-		}
+	conn, err := db.ConnectPostgres(ctx, cfg.PostgresDSN(), postgresOptions(cfg))
+	if err != nil {
+		return fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+	}
+	defer conn.Close()
 
-		log.Success("Extracted Table data",
-			zap.String("table", cfg.Table),
-			zap.Int("rows", len(td.Rows)),
-			zap.Int("columns", len(td.Columns)),
-		)
+	chConn, err := db.ConnectClickhouse(ctx, cfg.ClickHouseDSN(), clickhouseOptions(cfg))
+	if err != nil {
+		return fmt.Errorf("failed to connect to ClickHouse: %w", err)
+	}
+	defer chConn.Close()
+
+	sink, err := deadLetterSink(ctx, cfg.DeadLetter, chConn)
+	if err != nil {
+		return fmt.Errorf("failed to set up dead-letter sink: %w", err)
+	}
 
-		log.Info("Building ClickHouse schema")
+	stages, checker, err := batchStages(cfg.Table, cfg.Quality, cfg.Transform, sink)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errInvalidConfig, err)
+	}
+	defer recordQuality(checker, tr)
 
-		ddl, err := etl.BuildDDLQuery(cfg.Table, td.Columns)
-		if err != nil {
-			log.Error("failed to build DDL query", zap.Error(err))
-			return
+	opts := loadOptions(cfg, cfg.BatchSize, sink)
+	opts.Retry.OnRetry = func(int, error) { tr.Retries++ }
+
+	// a checkpoint means an earlier run finished its initial load, or was
+	// interrupted during it, and polling picks up from there
+	var store *checkpoint.Store
+	var lastSeen string
+	if cfg.Polling.Enabled {
+		if store, err = checkpoint.NewStore(cfg.StateDir); err != nil {
+			return err
+		}
+		if lastSeen, err = store.Load(cfg.Table); err != nil {
+			return err
 		}
+	}
 
-		chConn, err := db.ConnectClickhouse(cfg.ClickHouseURL)
+	if lastSeen != "" {
+		log.Info("Resuming polling from checkpoint, skipping the initial load",
+			zap.String("table", cfg.Table),
+			zap.String("last_seen", lastSeen))
+	} else if cfg.ChunkSize > 0 || ingestResume {
+		if err := ingestChunked(ctx, cfg, conn, chConn, mode, stages, opts, ingestResume, rep); err != nil {
+			return err
+		}
+		if cfg.Polling.Enabled {
+			lastSeen, err = etl.MaxDeltaValue(ctx, conn, cfg.Table, cfg.Polling.Deltacol)
+			if err != nil {
+				return fmt.Errorf("failed to determine last seen value: %w", err)
+			}
+		}
+		if err := saveCheckpoint(store, cfg.Table, lastSeen); err != nil {
+			return err
+		}
+	} else {
+		lastSeen, err = ingestFull(ctx, cfg, conn, chConn, mode, stages, opts, rep)
+		if saveErr := saveCheckpoint(store, cfg.Table, lastSeen); saveErr != nil {
+			return errors.Join(err, saveErr)
+		}
 		if err != nil {
-			log.Error("failed to connect to ClickHouse", zap.Error(err))
-			return
+			return err
 		}
-		defer chConn.Close()
+	}
+	tr.Watermark = lastSeen
 
-		log.Info("creating table in ClickHouse")
+	if cfg.Polling.Enabled {
+		ui.PrintSubtitle("Starting change data polling")
 
-		if err := etl.CreateTable(cfg.ClickHouseURL, ddl); err != nil {
-			log.Error("failed to create table", zap.Error(err))
-			return
+		if err := startPolling(ctx, cfg, conn, chConn, store, stages, opts, lastSeen, tr); err != nil {
+			return fmt.Errorf("polling stopped: %w", err)
 		}
+	}
 
-		log.Info("inserting data into ClickHouse")
+	log.Success("data ingestion complete",
+		zap.String("table", cfg.Table),
+		zap.Int("rows", tr.RowsWritten),
+	)
+	return nil
+}
 
-		if err := etl.InsertRows(cfg.ClickHouseURL, cfg.Table, etl.GetColumnNames(td.Columns), td.Rows, cfg.BatchSize); err != nil {
-			log.Error("failed to insert data", zap.Error(err))
-			return
-		}
+func ingestFull(ctx context.Context, cfg *config.Config, conn etl.Querier, chConn *sql.DB, mode etl.WriteMode, stages []etl.Stage, opts etl.LoadOptions, rep *report.Report) (string, error) {
+	log := log.StyledLog
+	tr := rep.Table(cfg.Table)
 
-		log.Success("initial data ingestion complete",
-			zap.String("table", cfg.Table),
-			zap.Int("rows", len(td.Rows)))
+	log.Info("extracting table data")
 
+	// with polling the rows come in delta order, so that the rows loaded
+	// before an interruption end at a watermark polling can resume from
+	var td *etl.TableData
+	err := etl.Retry(ctx, cfg.Table, opts.Retry, func() error {
+		var err error
 		if cfg.Polling.Enabled {
-			ui.PrintSubtitle("Starting change data polling")
+			td, err = etl.ExtractTableDataSince(ctx, conn, cfg.Table, cfg.Polling.Deltacol, "", &cfg.Limit, projection(cfg.Columns, cfg.ExcludeColumns))
+		} else {
+			td, err = etl.ExtractTableData(ctx, conn, cfg.Table, &cfg.Limit, projection(cfg.Columns, cfg.ExcludeColumns))
+		}
+		return err
+	})
 
-			lastSeen, err := determineLastSeen(td, cfg.Polling.Deltacol)
+	if err != nil {
+		return "", fmt.Errorf("failed to extract data from table: %w", err)
+	}
+	tr.RowsRead += len(td.Rows)
+	tr.Bytes += etl.RowBytes(td.Rows)
 
-			if err != nil {
-				log.Error("failed to determine last seen value", zap.Error(err))
-				return
-			}
+	log.Success("Extracted Table data",
+		zap.String("table", cfg.Table),
+		zap.Int("rows", len(td.Rows)),
+		zap.Int("columns", len(td.Columns)),
+	)
 
-			if err := startPolling(ctx, cfg, lastSeen); err != nil {
-				log.Error("failed to start polling", zap.Error(err))
-				return
-			}
+	log.Info("Building ClickHouse schema")
 
-		}
+	ddlOpts, err := ddlOptions(ctx, conn, cfg.Table, mode, cfg.UpsertKeys, cfg.Polling.Deltacol, cfg.Types, clusterOptions(cfg))
+	if err == nil {
+		ddlOpts, err = targetLayout(ddlOpts, stages)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve table layout: %w", err)
+	}
 
-		log.Success("data ingestion complete",
-			zap.String("table", cfg.Table),
-			zap.Int("rows", len(td.Rows)),
-		)
-	},
-}
+	cols, err := etl.StageSchema(td.Columns, stages...)
+	if err != nil {
+		return "", fmt.Errorf("failed to apply transforms to table schema: %w", err)
+	}
 
-func loadConfig() *config.Config {
-	log := log.StyledLog
+	log.Info("preparing table in ClickHouse", zap.String("mode", string(mode)))
+
+	target, err := etl.PrepareTarget(ctx, chConn, cfg.Target(), cols, mode, ddlOpts)
+	if err != nil {
+		return "", fmt.Errorf("failed to prepare target table: %w", err)
+	}
+	if target.DDL != "" {
+		tr.DDL = append(tr.DDL, target.DDL)
+	}
 
-	cfg, err := config.LoadConfig(ingestConfigPath)
+	log.Info("inserting data into ClickHouse")
 
+	stats, err := etl.LoadBatch(ctx, chConn, target.LoadTable, td, stages, opts)
+	tr.RowsWritten += stats.Inserted
+	tr.RowsRejected += stats.Rejected
+	tr.RowsDropped += stats.Dropped
+	if err != nil && mode == etl.ModeReplace {
+		warn(rep, "load into staging table did not complete, live table left untouched",
+			zap.String("table", cfg.Table),
+			zap.String("staging", target.LoadTable))
+	}
 	if err != nil {
-		log.Warn("Could not load config from file, falling back to flags", zap.Error(err))
-		cfg = &config.Config{
-			PostgreSQLURL: ingestPgURL,
-			ClickHouseURL: ingestChURL,
-			Table:         ingestTable,
-			Limit:         ingestLimit,
-			BatchSize:     ingestBatch,
-			Polling: config.PollingConfig{
-				Enabled:  ingestPoll,
-				Deltacol: ingestPollDelta,
-				Interval: ingestPollInt,
-			},
-		}
-	} else {
-		if ingestPgURL != "" {
-			cfg.PostgreSQLURL = ingestPgURL
-		}
-		if ingestChURL != "" {
-			cfg.ClickHouseURL = ingestChURL
-		}
-		if ingestTable != "" {
-			cfg.Table = ingestTable
-		}
-		if ingestLimit != 0 {
-			cfg.Limit = ingestLimit
-		}
-		if ingestBatch != 0 {
-			cfg.BatchSize = ingestBatch
+		var interrupted *etl.InterruptedError
+		if errors.As(err, &interrupted) {
+			warn(rep, "ingestion interrupted, stopping after the in-flight batch",
+				zap.String("table", cfg.Table),
+				zap.Int("rows_loaded", interrupted.Inserted),
+				zap.Int("rows_total", len(td.Rows)))
+			if !cfg.Polling.Enabled || mode == etl.ModeReplace || interrupted.Inserted == 0 {
+				return "", err
+			}
+			partialSeen, seenErr := etl.DeltaValueAt(td, cfg.Polling.Deltacol, interrupted.Inserted-1)
+			if seenErr != nil {
+				return "", errors.Join(err, seenErr)
+			}
+			return partialSeen, err
 		}
+		return "", fmt.Errorf("failed to insert data: %w", err)
+	}
 
-		if ingestPoll {
-			cfg.Polling.Enabled = true
-		}
-		if ingestPollDelta != "" {
-			cfg.Polling.Deltacol = ingestPollDelta
-		}
-		if ingestPollInt != 0 {
-			cfg.Polling.Interval = ingestPollInt
-		}
+	if err := target.Finalize(ctx, chConn, stats.Inserted); err != nil {
+		return "", fmt.Errorf("failed to swap staging table, live table left untouched: %w", err)
+	}
+
+	log.Success("initial data ingestion complete",
+		zap.String("table", cfg.Table),
+		zap.Int("rows", stats.Inserted))
+
+	if stats.Rejected > 0 {
+		warn(rep, fmt.Sprintf("%d rows rejected and sent to the dead-letter sink", stats.Rejected),
+			zap.String("table", cfg.Table),
+			zap.Int("rejected", stats.Rejected))
+	}
+	if stats.Dropped > 0 {
+		warn(rep, fmt.Sprintf("%d rows held back by data quality rules", stats.Dropped),
+			zap.String("table", cfg.Table),
+			zap.Int("dropped", stats.Dropped))
 	}
 
-	return cfg
+	if !cfg.Polling.Enabled {
+		return "", nil
+	}
 
+	lastSeen, err := determineLastSeen(td, cfg.Polling.Deltacol)
+	if err != nil {
+		return "", fmt.Errorf("failed to determine last seen value: %w", err)
+	}
+	return lastSeen, nil
 }
 
-func validateConfig(cfg *config.Config) bool {
-	log := log.StyledLog
+func loadConfig() (*config.Config, error) {
+	cfg, err := readConfig(ingestConfigPath)
+	if err == nil {
+		cfg, err = selectPipeline(cfg, ingestPipeline)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	if cfg.PostgreSQLURL == "" || cfg.ClickHouseURL == "" || cfg.Table == "" {
-		log.Error("Missing required config values. Provide them in YAML or as flags.",
-			zap.String("pg_url", cfg.PostgreSQLURL),
-			zap.String("ch_url", cfg.ClickHouseURL),
-			zap.String("table", cfg.Table),
-		)
-		return false
+	if ingestPgURL != "" {
+		cfg.PostgreSQLURL = ingestPgURL
+	}
+	if ingestChURL != "" {
+		cfg.ClickHouseURL = ingestChURL
+	}
+	if ingestTable != "" {
+		cfg.Table = ingestTable
+	}
+	if len(ingestColumns) > 0 {
+		cfg.Columns = ingestColumns
+	}
+	if len(ingestExcludeColumns) > 0 {
+		cfg.ExcludeColumns = ingestExcludeColumns
+	}
+	if ingestLimit != 0 {
+		cfg.Limit = ingestLimit
+	}
+	if ingestBatch != 0 {
+		cfg.BatchSize = ingestBatch
+	}
+	if ingestMode != "" {
+		cfg.Mode = ingestMode
+	}
+	if len(ingestUpsertKeys) > 0 {
+		cfg.UpsertKeys = ingestUpsertKeys
+	}
+	if ingestChunkSize != 0 {
+		cfg.ChunkSize = ingestChunkSize
 	}
 
-	if cfg.Polling.Enabled {
-		if cfg.Polling.Deltacol == "" {
-			log.Error("Missing delta column for polling. Provide it in YAML or with --poll-delta flag.")
-			return false
-		}
-		if cfg.Polling.Interval <= 0 {
-			log.Error("Invalid polling interval. Must be greater than 0.")
-			return false
-		}
+	if ingestPoll {
+		cfg.Polling.Enabled = true
+	}
+	if ingestPollDelta != "" {
+		cfg.Polling.Deltacol = ingestPollDelta
+	}
+	if ingestPollInt != 0 {
+		cfg.Polling.Interval = ingestPollInt
+	}
+	if ingestPollMaxFailures != 0 {
+		cfg.Polling.MaxFailures = ingestPollMaxFailures
+	}
+	if ingestDLQFile != "" {
+		cfg.DeadLetter.Type = "file"
+		cfg.DeadLetter.Path = ingestDLQFile
+	}
+	if ingestMaxErrors != 0 {
+		cfg.DeadLetter.MaxErrors = ingestMaxErrors
 	}
 
-	return true
+	cfg.ApplyDefaults()
+	if err := validateConfig(cfg, false); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidConfig, err)
+	}
+	if cfg.Table == "" {
+		return nil, fmt.Errorf("%w: table is required. Provide it in YAML or with --table", errInvalidConfig)
+	}
+	return cfg, nil
 }
 
 func UI_itoa(n int) string {
-	if n == 0 {
+	if n <= 0 {
 		return "all"
 	}
 	return fmt.Sprintf("%d", n)
@@ -212,10 +355,22 @@ func init() {
 	ingestCmd.Flags().StringVar(&ingestPgURL, "pg-url", "", "PostgreSQL connection URL")
 	ingestCmd.Flags().StringVar(&ingestChURL, "ch-url", "", "ClickHouse connection URL")
 	ingestCmd.Flags().StringVar(&ingestTable, "table", "", "Table name to ingest")
-	ingestCmd.Flags().IntVar(&ingestLimit, "limit", 1000, "Limit rows to fetch from PG")
-	ingestCmd.Flags().IntVar(&ingestBatch, "batch-size", 500, "Rows per ClickHouse insert")
+	ingestCmd.Flags().StringVar(&ingestPipeline, "pipeline", "", "Ingest the pipeline with this name from the config")
+	ingestCmd.Flags().StringSliceVar(&ingestColumns, "columns", nil, "Only read these columns from the source table")
+	ingestCmd.Flags().StringSliceVar(&ingestExcludeColumns, "exclude-columns", nil, "Skip these columns of the source table")
+	ingestCmd.Flags().IntVar(&ingestLimit, "limit", 0, "Limit rows to fetch from PG (default 1000, -1 for all)")
+	ingestCmd.Flags().IntVar(&ingestBatch, "batch-size", 0, "Rows per ClickHouse insert (default 500)")
+	ingestCmd.Flags().StringVar(&ingestMode, "mode", "", "Write mode: append, truncate, replace, upsert or error-if-exists")
+	ingestCmd.Flags().StringSliceVar(&ingestUpsertKeys, "upsert-keys", nil, "Dedup key columns for upsert mode (default: the Postgres primary key)")
+	ingestCmd.Flags().IntVar(&ingestChunkSize, "chunk-size", 0, "Load in primary-key chunks of this many rows, recording progress after each")
+	ingestCmd.Flags().BoolVar(&ingestResume, "resume", false, "Resume an interrupted chunked load from the first unfinished chunk")
 	ingestCmd.Flags().BoolVar(&ingestPoll, "poll", false, "Continue polling for changes after initial ingest")
 	ingestCmd.Flags().StringVar(&ingestPollDelta, "poll-delta", "", "Column name to track changes (usually a timestamp)")
 	ingestCmd.Flags().IntVar(&ingestPollInt, "poll-interval", 0, "Polling interval in seconds")
+	ingestCmd.Flags().IntVar(&ingestPollMaxFailures, "poll-max-failures", 0, "Consecutive failed poll cycles before exiting (default 10, -1 for unlimited)")
+	ingestCmd.Flags().StringVar(&ingestDLQFile, "dlq-file", "", "Write rows ClickHouse rejects to this JSONL file instead of failing the batch")
+	ingestCmd.Flags().IntVar(&ingestMaxErrors, "max-errors", 0, "Rejected rows tolerated before aborting (default 1000, -1 for unlimited)")
+	ingestCmd.Flags().StringVar(&ingestReportPath, "report", "", "Write a JSON run report to this file")
+	ingestCmd.Flags().StringVar(&ingestOutput, "output", "text", "Output format: text, or json to print the run report on stdout")
 	rootCmd.AddCommand(ingestCmd)
 }
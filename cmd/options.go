@@ -0,0 +1,231 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"pgtoch/config"
+	ui "pgtoch/internal/UI"
+	"pgtoch/internal/db"
+	"pgtoch/internal/dlq"
+	"pgtoch/internal/etl"
+	"pgtoch/internal/quality"
+	"pgtoch/internal/transform"
+	"strings"
+)
+
+func retryPolicy(rc config.RetryConfig) etl.RetryConfig {
+	policy := etl.DefaultRetryConfig()
+	if rc.MaxAttempts > 0 {
+		policy.MaxAttempts = rc.MaxAttempts
+	}
+	if rc.BaseDelay > 0 {
+		policy.BaseDelay = rc.BaseDelay
+	}
+	if rc.MaxDelay > 0 {
+		policy.MaxDelay = rc.MaxDelay
+	}
+	if rc.Jitter != nil {
+		policy.Jitter = *rc.Jitter
+	}
+	return policy
+}
+
+func deadLetterSink(ctx context.Context, dc config.DeadLetterConfig, chConn *sql.DB) (etl.DeadLetterSink, error) {
+	switch dc.Type {
+	case "":
+		if dc.Path != "" {
+			return dlq.NewFileSink(dc.Path), nil
+		}
+		return nil, nil
+	case "file":
+		if dc.Path == "" {
+			return nil, fmt.Errorf("dead-letter type file requires a path")
+		}
+		return dlq.NewFileSink(dc.Path), nil
+	case "clickhouse":
+		sink, err := dlq.NewClickHouseSink(ctx, chConn)
+		if err != nil {
+			return nil, err
+		}
+		return sink, nil
+	default:
+		return nil, fmt.Errorf("unknown dead-letter type %q (expected file or clickhouse)", dc.Type)
+	}
+}
+
+func projection(columns, exclude []string) etl.Projection {
+	return etl.Projection{Columns: columns, Exclude: exclude}
+}
+
+func ddlOptions(ctx context.Context, pgConn etl.Querier, table string, mode etl.WriteMode, keys []string, deltaCol string, types map[string]string, cluster *etl.ClusterOptions) (etl.DDLOptions, error) {
+	if mode != etl.ModeUpsert {
+		return etl.DDLOptions{Types: types, Cluster: cluster}, nil
+	}
+
+	if len(keys) == 0 {
+		pk, err := etl.PrimaryKey(ctx, pgConn, table)
+		if err != nil {
+			return etl.DDLOptions{}, err
+		}
+		if len(pk) == 0 {
+			return etl.DDLOptions{}, fmt.Errorf("table %s has no primary key; set upsert keys explicitly", table)
+		}
+		keys = pk
+	}
+
+	return etl.DDLOptions{OrderBy: keys, Version: deltaCol, Types: types, Cluster: cluster}, nil
+}
+
+// clusterOptions returns nil unless a cluster is configured.
+func clusterOptions(cfg *config.Config) *etl.ClusterOptions {
+	cl := cfg.Cluster
+	if cl.Name == "" {
+		return nil
+	}
+	return &etl.ClusterOptions{
+		Name:          cl.Name,
+		ZooKeeperPath: cl.ZooKeeperPath,
+		ReplicaName:   cl.ReplicaName,
+		ShardingKey:   cl.ShardingKey,
+		InsertLocal:   cl.Insert == "local",
+	}
+}
+
+func postgresOptions(cfg *config.Config) db.PostgresOptions {
+	pg := cfg.Postgres
+	return db.PostgresOptions{
+		MaxConns:         pg.MaxConns,
+		MinConns:         pg.MinConns,
+		ConnectTimeout:   pg.ConnectTimeout,
+		StatementTimeout: pg.StatementTimeout,
+		ApplicationName:  pg.ApplicationName,
+		SSLMode:          pg.SSLMode,
+		SSLRootCert:      pg.SSLRootCert,
+		SSLCert:          pg.SSLCert,
+		SSLKey:           pg.SSLKey,
+		ReadOnly:         pg.ReadOnly == nil || *pg.ReadOnly,
+		ReplicaURL:       cfg.PostgresReplicaDSN(),
+	}
+}
+
+func clickhouseOptions(cfg *config.Config) db.ClickHouseOptions {
+	ch := cfg.ClickHouse
+	return db.ClickHouseOptions{
+		Secure:           ch.Secure,
+		SkipVerify:       ch.SkipVerify,
+		CAFile:           ch.CAFile,
+		CertFile:         ch.CertFile,
+		KeyFile:          ch.KeyFile,
+		DialTimeout:      ch.DialTimeout,
+		ReadTimeout:      ch.ReadTimeout,
+		Compression:      ch.Compression,
+		MaxOpenConns:     ch.MaxOpenConns,
+		ConnOpenStrategy: ch.ConnOpenStrategy,
+		HTTPHeaders:      ch.HTTPHeaders,
+		HTTPPath:         ch.HTTPPath,
+		HTTPBasicAuth:    ch.HTTPBasicAuth,
+	}
+}
+
+func loadOptions(cfg *config.Config, batchSize int, sink etl.DeadLetterSink) etl.LoadOptions {
+	return etl.LoadOptions{
+		BatchSize:  batchSize,
+		Retry:      retryPolicy(cfg.Retry),
+		DeadLetter: sink,
+		MaxErrors:  cfg.DeadLetter.MaxErrors,
+	}
+}
+
+func qualityChecker(table string, rules []config.QualityRule, sink etl.DeadLetterSink) (*quality.Checker, error) {
+	converted := make([]quality.Rule, len(rules))
+	for i, r := range rules {
+		converted[i] = quality.Rule{
+			Column:    r.Column,
+			Check:     r.Rule,
+			Min:       r.Min,
+			Max:       r.Max,
+			Pattern:   r.Pattern,
+			Values:    r.Values,
+			MaxLength: r.MaxLength,
+			Action:    r.Action,
+			Default:   r.Default,
+		}
+	}
+	return quality.New(table, converted, sink)
+}
+
+func transformer(table string, tc config.TransformConfig) (*transform.Transformer, error) {
+	spec := transform.Spec{
+		Rename: tc.Rename,
+		Drop:   tc.Drop,
+		Cast:   tc.Cast,
+		Hash:   tc.Hash,
+		Salt:   tc.Salt,
+	}
+	for _, m := range tc.Mask {
+		spec.Mask = append(spec.Mask, transform.Mask{Column: m.Column, Keep: m.Keep})
+	}
+	for _, c := range tc.Computed {
+		spec.Computed = append(spec.Computed, transform.Computed{Name: c.Name, Expression: c.Expression})
+	}
+	if spec.Empty() {
+		return nil, nil
+	}
+	return transform.New(table, spec)
+}
+
+// batchStages builds the per-batch stages for a table: quality rules run on
+// the source columns first, then the column transforms.
+func batchStages(table string, rules []config.QualityRule, tc config.TransformConfig, sink etl.DeadLetterSink) ([]etl.Stage, *quality.Checker, error) {
+	checker, err := qualityChecker(table, rules, sink)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid data quality rules: %w", err)
+	}
+	stages := []etl.Stage{checker}
+
+	t, err := transformer(table, tc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid transforms: %w", err)
+	}
+	if t != nil {
+		stages = append(stages, t)
+	}
+	return stages, checker, nil
+}
+
+// targetLayout rewrites the source column names in a table layout to the
+// names they have after the transform stages.
+func targetLayout(opts etl.DDLOptions, stages []etl.Stage) (etl.DDLOptions, error) {
+	orderBy := make([]string, len(opts.OrderBy))
+	for i, col := range opts.OrderBy {
+		name, ok := etl.StageColumnName(col, stages...)
+		if !ok {
+			return etl.DDLOptions{}, fmt.Errorf("key column %s is dropped by the transforms", col)
+		}
+		orderBy[i] = name
+	}
+	opts.OrderBy = orderBy
+
+	if opts.Version != "" {
+		name, ok := etl.StageColumnName(opts.Version, stages...)
+		if !ok {
+			return etl.DDLOptions{}, fmt.Errorf("version column %s is dropped by the transforms", opts.Version)
+		}
+		opts.Version = name
+	}
+	return opts, nil
+}
+
+func printQualitySummary(checker *quality.Checker) {
+	violations := checker.Violations()
+	if len(violations) == 0 {
+		return
+	}
+
+	lines := make([]string, len(violations))
+	for i, v := range violations {
+		lines[i] = fmt.Sprintf("%s: %d violations (%s)", v.Rule, v.Count, v.Action)
+	}
+	ui.PrintBox("Data Quality: "+checker.Table(), strings.Join(lines, "\n"))
+}
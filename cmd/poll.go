@@ -2,19 +2,22 @@ package cmd
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"pgtoch/config"
 	ui "pgtoch/internal/UI"
+	"pgtoch/internal/checkpoint"
+	"pgtoch/internal/db"
 	"pgtoch/internal/etl"
 	"pgtoch/internal/log"
 	"pgtoch/internal/poller"
+	"pgtoch/internal/report"
 	"time"
 
-	"github.com/jackc/pgx/v5"
 	"go.uber.org/zap"
 )
 
-func startPolling(ctx context.Context, cfg *config.Config, lastSeen string) error {
+func startPolling(ctx context.Context, cfg *config.Config, pgConn *db.Postgres, chConn *sql.DB, store *checkpoint.Store, stages []etl.Stage, opts etl.LoadOptions, lastSeen string, tr *report.TableReport) error {
 	log := log.StyledLog
 	log.Info("Starting chg data polling..")
 
@@ -28,18 +31,10 @@ func startPolling(ctx context.Context, cfg *config.Config, lastSeen string) erro
 		"Table: "+cfg.Table+"\n"+
 			"Delta Column: "+cfg.Polling.Deltacol+"\n"+
 			"Interval: "+fmt.Sprintf("%d seconds", cfg.Polling.Interval)+"\n"+
+			"Max Consecutive Failures: "+maxFailuresLabel(cfg.Polling.MaxFailures)+"\n"+
 			"Starting From: "+startFrom)
 
-	pgConn, err := pgx.Connect(ctx, cfg.PostgreSQLURL)
-
-	if err != nil {
-		fmt.Errorf("Failed to connect to PostgreSQL for polling: %w", err)
-		return err
-	}
-
-	defer pgConn.Close(ctx)
-
-	processNewData := func(data *etl.TableData) error {
+	processNewData := func(ctx context.Context, data *etl.TableData) error {
 		if len(data.Rows) > 0 {
 			log.Info(fmt.Sprintf("Processing new batch data: %d rows", len(data.Rows)),
 				zap.Int("rows", len(data.Rows)),
@@ -49,16 +44,30 @@ func startPolling(ctx context.Context, cfg *config.Config, lastSeen string) erro
 			log.Info("No new data found in this cycle")
 		}
 
-		return etl.InsertRows(cfg.ClickHouseURL, cfg.Table, etl.GetColumnNames(data.Columns), data.Rows, cfg.BatchSize)
+		tr.RowsRead += len(data.Rows)
+		tr.Bytes += etl.RowBytes(data.Rows)
+
+		stats, err := etl.LoadBatch(ctx, chConn, clusterOptions(cfg).LoadTable(cfg.Target()), data, stages, opts)
+		tr.RowsWritten += stats.Inserted
+		tr.RowsRejected += stats.Rejected
+		tr.RowsDropped += stats.Dropped
+		return err
 	}
 
 	pollConfig := poller.PollConfig{
-		Table:     cfg.Table,
-		DeltaCol:  cfg.Polling.Deltacol,
-		Interval:  time.Duration(cfg.Polling.Interval) * time.Second,
-		Limit:     &cfg.Limit,
-		StartFrom: lastSeen,
-		OnData:    processNewData,
+		Table:       cfg.Table,
+		DeltaCol:    cfg.Polling.Deltacol,
+		Interval:    time.Duration(cfg.Polling.Interval) * time.Second,
+		Limit:       &cfg.Limit,
+		Projection:  projection(cfg.Columns, cfg.ExcludeColumns),
+		StartFrom:   lastSeen,
+		MaxFailures: cfg.Polling.MaxFailures,
+		Retry:       opts.Retry,
+		OnData:      processNewData,
+		OnCheckpoint: func(lastSeen string) error {
+			tr.Watermark = lastSeen
+			return store.Save(cfg.Table, lastSeen)
+		},
 	}
 	p := poller.NewPoller(pgConn, pollConfig)
 
@@ -66,6 +75,30 @@ func startPolling(ctx context.Context, cfg *config.Config, lastSeen string) erro
 
 }
 
+// saveCheckpoint records the watermark the initial load of table reached, so
+// that the next run polls from there instead of loading again.
+func saveCheckpoint(store *checkpoint.Store, table, lastSeen string) error {
+	if store == nil || lastSeen == "" {
+		return nil
+	}
+	if err := store.Save(table, lastSeen); err != nil {
+		return err
+	}
+	log.StyledLog.Info("Saved checkpoint", zap.String("table", table), zap.String("last_seen", lastSeen))
+	return nil
+}
+
+func maxFailuresLabel(n int) string {
+	switch {
+	case n < 0:
+		return "unlimited"
+	case n == 0:
+		return fmt.Sprintf("%d", poller.DefaultMaxFailures)
+	default:
+		return fmt.Sprintf("%d", n)
+	}
+}
+
 func determineLastSeen(td *etl.TableData, deltaCol string) (string, error) {
 
 	log := log.StyledLog
@@ -75,33 +108,9 @@ func determineLastSeen(td *etl.TableData, deltaCol string) (string, error) {
 		return "", nil
 	}
 
-	deltaColIndex := -1
-
-	for i, col := range td.Columns {
-		if col.Name == deltaCol {
-			deltaColIndex = i
-			break
-		}
-	}
-
-	if deltaColIndex == -1 {
-		return "", fmt.Errorf("delta column %s not found in table", deltaCol)
-	}
-
-	lastRow := td.Rows[len(td.Rows)-1]
-	var lastSeenValue string
-
-	switch v := lastRow[deltaColIndex].(type) {
-	case string:
-		lastSeenValue = v
-	case int, int32, int64, int8, int16:
-		lastSeenValue = fmt.Sprintf("%d", v)
-	case float64, float32:
-		lastSeenValue = fmt.Sprintf("%f", v)
-	case time.Time:
-		lastSeenValue = v.Format(time.RFC3339)
-	default:
-		lastSeenValue = fmt.Sprintf("%v", v)
+	lastSeenValue, err := etl.LastDeltaValue(td, deltaCol)
+	if err != nil {
+		return "", err
 	}
 
 	log.Info("Determined last seen value for delta tracking",
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"fmt"
+	"pgtoch/config"
+	ui "pgtoch/internal/UI"
+	"pgtoch/internal/db"
+	"pgtoch/internal/etl"
+	"pgtoch/internal/log"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var (
+	previewConfigPath, previewPgURL, previewTable, previewPipeline string
+	previewRows                                                    int
+)
+
+// previewCellWidth keeps wide values such as JSON documents from pushing the
+// other columns off the screen.
+const previewCellWidth = 40
+
+var previewCmd = &cobra.Command{
+	Use:   "preview",
+	Short: "Show sample rows of a postgres table next to its clickhouse types",
+	Run: func(cmd *cobra.Command, args []string) {
+		ui.PrintTitle("Table Preview")
+		ui.PrintSubtitle("sample rows and the clickhouse schema they map to")
+
+		ctx := cmd.Context()
+		log := log.StyledLog
+
+		cfg, err := readConfig(previewConfigPath)
+		if err == nil {
+			cfg, err = selectPipeline(cfg, previewPipeline)
+		}
+		if err != nil {
+			log.Error("Failed to load config", zap.Error(err))
+			setExitCode(exitConfig)
+			return
+		}
+		if previewPgURL != "" {
+			cfg.PostgreSQLURL = previewPgURL
+		}
+		if previewTable != "" && previewTable != cfg.Table {
+			// the projection and types of the config belong to another table
+			cfg.Table, cfg.Columns, cfg.ExcludeColumns, cfg.Types = previewTable, nil, nil, nil
+		}
+		if previewRows <= 0 {
+			log.Error("--rows must be positive")
+			setExitCode(exitConfig)
+			return
+		}
+		if (cfg.PostgreSQLURL == "" && !config.PostgresFromEnv()) || cfg.Table == "" {
+			log.Error("Missing required config values. Provide pg_url and table in YAML or as flags.")
+			setExitCode(exitConfig)
+			return
+		}
+
+		conn, err := db.ConnectPostgres(ctx, cfg.PostgresDSN(), postgresOptions(cfg))
+		if err != nil {
+			log.Error("Failed to connect to PostgreSQL", zap.Error(err))
+			setExitCode(exitFailure)
+			return
+		}
+		defer conn.Close()
+
+		td, err := etl.ExtractTableData(ctx, conn, cfg.Table, &previewRows, projection(cfg.Columns, cfg.ExcludeColumns))
+		if err != nil {
+			log.Error("Failed to read sample rows", zap.Error(err))
+			setExitCode(exitFailure)
+			return
+		}
+
+		schema := make([][]string, len(td.Columns))
+		headers := make([]string, len(td.Columns))
+		var unsupported []string
+		for i, col := range td.Columns {
+			chType, ok := etl.ColumnType(col, cfg.Types)
+			if !ok {
+				chType = "unsupported"
+				unsupported = append(unsupported, col.Name)
+			}
+			schema[i] = []string{col.Name, col.Type, chType}
+			headers[i] = col.Name
+		}
+
+		ui.PrintHighlight("Proposed ClickHouse schema")
+		ui.DisplayTable([]string{"Column", "PostgreSQL", "ClickHouse"}, schema)
+
+		ui.PrintHighlight(fmt.Sprintf("First %d rows of %s", len(td.Rows), cfg.Table))
+		rows := make([][]string, len(td.Rows))
+		for i, row := range td.Rows {
+			rows[i] = make([]string, len(row))
+			for j, v := range row {
+				rows[i][j] = previewCell(v)
+			}
+		}
+		ui.DisplayTable(headers, rows)
+
+		if len(unsupported) > 0 {
+			ui.PrintWarning(fmt.Sprintf("No ClickHouse type for %s; exclude them or set their types", strings.Join(unsupported, ", ")))
+		}
+	},
+}
+
+func previewCell(v any) string {
+	var s string
+	switch v := v.(type) {
+	case nil:
+		s = "NULL"
+	case []byte:
+		s = fmt.Sprintf("%x", v)
+	case time.Time:
+		s = v.Format(time.RFC3339Nano)
+	default:
+		s = fmt.Sprint(v)
+	}
+	s = strings.ReplaceAll(s, "\n", " ")
+	if r := []rune(s); len(r) > previewCellWidth {
+		s = string(r[:previewCellWidth-1]) + "…"
+	}
+	return s
+}
+
+func init() {
+	previewCmd.Flags().StringVar(&previewConfigPath, "config", "", "Path to YAML config file (default: .pgtoch.yaml)")
+	previewCmd.Flags().StringVar(&previewPgURL, "pg-url", "", "PostgreSQL connection URL")
+	previewCmd.Flags().StringVar(&previewTable, "table", "", "Table name to preview")
+	previewCmd.Flags().StringVar(&previewPipeline, "pipeline", "", "Preview the table of the pipeline with this name from the config")
+	previewCmd.Flags().IntVar(&previewRows, "rows", 20, "Sample rows to show")
+	rootCmd.AddCommand(previewCmd)
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"pgtoch/internal/etl"
+	"pgtoch/internal/log"
+	"pgtoch/internal/quality"
+	"pgtoch/internal/report"
+
+	"go.uber.org/zap"
+)
+
+var errInvalidConfig = errors.New("invalid configuration")
+
+// finishRun logs the outcome of a command, sets the process exit code and
+// writes the run report where requested.
+func finishRun(ctx context.Context, rep *report.Report, err error, reportPath, output string) {
+	log := log.StyledLog
+
+	code, status := exitOK, report.StatusSucceeded
+	switch {
+	case err == nil:
+	case interrupted(ctx, err):
+		code, status = exitInterrupted, report.StatusInterrupted
+		log.Warn(rep.Command+" interrupted", zap.Error(err))
+	default:
+		code, status = exitCodeFor(err), report.StatusFailed
+		log.Error(err.Error(), zap.String("command", rep.Command))
+	}
+
+	rep.Finish(status, code, err)
+	setExitCode(code)
+
+	if reportPath != "" {
+		if err := rep.WriteFile(reportPath); err != nil {
+			log.Error("Failed to write run report", zap.Error(err))
+			setExitCode(exitFailure)
+		}
+	}
+	if output == "json" {
+		if err := rep.Encode(os.Stdout); err != nil {
+			log.Error("Failed to print run report", zap.Error(err))
+			setExitCode(exitFailure)
+		}
+	}
+}
+
+func interrupted(ctx context.Context, err error) bool {
+	var ie *etl.InterruptedError
+	return ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.As(err, &ie)
+}
+
+func exitCodeFor(err error) int {
+	switch {
+	case errors.Is(err, errInvalidConfig):
+		return exitConfig
+	case errors.Is(err, etl.ErrTooManyRejected), errors.Is(err, quality.ErrRuleViolated):
+		return exitRejected
+	default:
+		return exitFailure
+	}
+}
+
+func warn(rep *report.Report, msg string, fields ...zap.Field) {
+	log.StyledLog.Warn(msg, fields...)
+	rep.Warn(msg)
+}
+
+func recordQuality(checker *quality.Checker, tr *report.TableReport) {
+	printQualitySummary(checker)
+
+	for _, v := range checker.Violations() {
+		if v.Count == 0 {
+			continue
+		}
+		if tr.QualityViolations == nil {
+			tr.QualityViolations = make(map[string]int)
+		}
+		tr.QualityViolations[v.Rule] += v.Count
+	}
+}
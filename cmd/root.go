@@ -1,10 +1,20 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"path/filepath"
 	ui "pgtoch/internal/UI"
+	"pgtoch/internal/etl"
 	"pgtoch/internal/log"
+	"pgtoch/internal/metrics"
+	"pgtoch/internal/tracing"
+	"syscall"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/spf13/cobra"
@@ -13,17 +23,28 @@ import (
 
 var (
 	useInteractive bool
+	consoleConfig  string
+	drainTimeout   time.Duration
+	metricsAddr    string
+	traceConfig    tracing.Config
+	finishTracing  = func() {}
 )
 
 var rootCmd = &cobra.Command{
 	Use:   "pgtoch",
 	Short: "Etl from postgres ==> clickhouse",
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
-		if useInteractive && cmd.Name() == "pgtoch" {
-			showInteractiveUI()
+		cmd.SetContext(etl.WithDrainTimeout(cmd.Context(), drainTimeout))
+		if metricsAddr != "" {
+			startMetricsServer(cmd.Context(), metricsAddr)
 		}
+		startTracing(cmd)
 	},
 	Run: func(cmd *cobra.Command, args []string) {
+		if useInteractive {
+			setExitCode(showInteractiveUI(cmd.Context()))
+			return
+		}
 		showLogo()
 	},
 }
@@ -39,21 +60,104 @@ func Execute() {
 	if len(os.Args) > 1 && (os.Args[1] == "--help" || os.Args[1] == "help" || os.Args[1] == "-h") {
 		showLogo()
 	}
-	if err := rootCmd.Execute(); err != nil {
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	err := rootCmd.ExecuteContext(ctx)
+	finishTracing()
+	if err != nil {
 		log.StyledLog.Error("Error executing command", zap.Error(err))
-		os.Exit(1)
+		os.Exit(exitConfig)
 	}
+
+	if ctx.Err() != nil {
+		log.StyledLog.Warn("Shutdown complete after interrupt")
+		setExitCode(exitInterrupted)
+	}
+	stop()
+	os.Exit(exitCode)
 }
 
 func init() {
 	rootCmd.PersistentFlags().BoolVarP(&useInteractive, "interactive", "i", false, "Use Interactive mode TUI Mode")
+	rootCmd.Flags().StringVar(&consoleConfig, "config", "", "Config file the interactive console reads connections and pipelines from (default: .pgtoch.yaml)")
+	rootCmd.PersistentFlags().StringVar(&metricsAddr, "metrics-addr", "", "Expose Prometheus metrics on this address, e.g. :9102")
+	rootCmd.PersistentFlags().StringVar(&traceConfig.Exporter, "trace-exporter", "", "Export OpenTelemetry traces: otlp or file")
+	rootCmd.PersistentFlags().StringVar(&traceConfig.Endpoint, "trace-endpoint", "", "OTLP/HTTP endpoint URL (default: OTEL_EXPORTER_OTLP_ENDPOINT or http://localhost:4318)")
+	rootCmd.PersistentFlags().StringVar(&traceConfig.File, "trace-file", "pgtoch-traces.json", "File the file exporter appends spans to")
+	rootCmd.PersistentFlags().DurationVar(&drainTimeout, "drain-timeout", 30*time.Second, "Time allowed to finish the in-flight batch after SIGINT/SIGTERM")
 }
 
-func showInteractiveUI() {
-	p := tea.NewProgram(ui.NewAppModel(), tea.WithAltScreen())
-	if _, err := p.Run(); err != nil {
+func startMetricsServer(ctx context.Context, addr string) {
+	log.StyledLog.Info("Serving Prometheus metrics", zap.String("addr", addr), zap.String("path", "/metrics"))
+	go func() {
+		if err := metrics.Serve(ctx, addr); err != nil {
+			log.StyledLog.Error("Metrics server stopped", zap.Error(err))
+		}
+	}()
+}
+
+// startTracing installs the exporter and opens a span covering the whole
+// command, so every stage below it shares one trace.
+func startTracing(cmd *cobra.Command) {
+	ctx := cmd.Context()
+	shutdown, err := tracing.Setup(ctx, traceConfig)
+	if err != nil {
+		log.StyledLog.Error("Tracing disabled", zap.Error(err))
+		return
+	}
+
+	ctx, span := tracing.Start(ctx, cmd.CommandPath())
+	cmd.SetContext(ctx)
+
+	finishTracing = func() {
+		span.End()
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdown(flushCtx); err != nil {
+			log.StyledLog.Warn("Failed to flush traces", zap.Error(err))
+		}
+	}
+}
+
+// showInteractiveUI runs the console and returns the exit code. It owns the
+// terminal, so the log goes to a file and the styled output of the runs it
+// starts is dropped until it quits.
+func showInteractiveUI(ctx context.Context) int {
+	backend, err := newConsoleBackend(consoleConfig)
+	if err != nil {
+		log.StyledLog.Error("Failed to load config", zap.Error(err))
+		return exitConfig
+	}
+	defer backend.Close()
+
+	logPath := filepath.Join(os.TempDir(), "pgtoch-console.log")
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		log.StyledLog.Error("Failed to open console log", zap.Error(err))
+		return exitFailure
+	}
+	defer logFile.Close()
+	log.RedirectTo(logFile)
+	ui.Output = io.Discard
+
+	model := ui.NewAppModel(ctx, backend)
+	model.LogPath = logPath
+	p := tea.NewProgram(model, tea.WithAltScreen(), tea.WithContext(ctx))
+	_, err = p.Run()
+	if !model.Close(drainTimeout) {
+		log.Logger.Warn("Console runs still going after the drain timeout", zap.Duration("timeout", drainTimeout))
+	}
+
+	ui.Output = os.Stdout
+	log.InitLogger()
+	if err != nil && !errors.Is(err, tea.ErrProgramKilled) {
 		log.StyledLog.Error("Error running interactive UI", zap.Error(err))
-		os.Exit(1)
+		return exitFailure
 	}
-	os.Exit(0)
+	return exitOK
 }
@@ -0,0 +1,267 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"pgtoch/config"
+	ui "pgtoch/internal/UI"
+	"pgtoch/internal/checkpoint"
+	"pgtoch/internal/db"
+	"pgtoch/internal/etl"
+	"pgtoch/internal/events"
+	"pgtoch/internal/log"
+	"pgtoch/internal/poller"
+	"pgtoch/internal/quality"
+	"pgtoch/internal/supervisor"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var (
+	syncConfigPath, syncPgURL, syncChURL, syncStateDir, syncPipeline string
+)
+
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Run a poller for every table pipeline in the config",
+	Run: func(cmd *cobra.Command, args []string) {
+		ui.PrintTitle("Multi-table Sync")
+		ui.PrintSubtitle("polling every configured table from postgres to clickhouse")
+
+		log := log.StyledLog
+
+		cfg, err := readConfig(syncConfigPath)
+		if err != nil {
+			log.Error("Failed to load config", zap.Error(err))
+			setExitCode(exitConfig)
+			return
+		}
+		if syncPgURL != "" {
+			cfg.PostgreSQLURL = syncPgURL
+		}
+		if syncChURL != "" {
+			cfg.ClickHouseURL = syncChURL
+		}
+		if syncStateDir != "" {
+			cfg.StateDir = syncStateDir
+		}
+
+		cfg.ApplyDefaults()
+		if err := validateConfig(cfg, true); err != nil {
+			log.Error("Invalid config", zap.Error(err))
+			setExitCode(exitConfig)
+			return
+		}
+		pipelines := cfg.Pipelines
+		if syncPipeline != "" {
+			pipelines = slices.DeleteFunc(slices.Clone(pipelines), func(p config.PipelineConfig) bool {
+				return p.Name != syncPipeline
+			})
+			if len(pipelines) == 0 {
+				log.Error("No pipeline with that name in the config", zap.String("pipeline", syncPipeline))
+				setExitCode(exitConfig)
+				return
+			}
+		}
+
+		ctx := cmd.Context()
+
+		store, err := checkpoint.NewStore(cfg.StateDir)
+		if err != nil {
+			log.Error("Failed to open checkpoint store", zap.Error(err))
+			setExitCode(exitFailure)
+			return
+		}
+
+		var summary []string
+		for _, p := range pipelines {
+			summary = append(summary, fmt.Sprintf("%s: %s -> %s, %s, delta %s every %ds", p.Name, p.Table, p.Target(), p.Mode, p.Polling.Deltacol, p.Polling.Interval))
+		}
+		ui.PrintBox("Pipelines", strings.Join(summary, "\n"))
+
+		conns := newSyncConnections(cfg.DeadLetter)
+		defer conns.Close()
+
+		sup := supervisor.New(etl.RetryConfig{
+			BaseDelay: time.Second,
+			MaxDelay:  2 * time.Minute,
+			Jitter:    true,
+		})
+		var checkers []*quality.Checker
+		for _, p := range pipelines {
+			pc, err := cfg.Pipeline(p.Name)
+			if err != nil {
+				log.Error("Failed to resolve pipeline", zap.String("pipeline", p.Name), zap.Error(err))
+				setExitCode(exitConfig)
+				return
+			}
+			pgPool, chConn, sink, err := conns.get(ctx, pc)
+			if err != nil {
+				log.Error("Failed to connect pipeline", zap.String("pipeline", p.Name), zap.Error(err))
+				setExitCode(exitFailure)
+				return
+			}
+
+			stages, checker, err := batchStages(p.Table, p.Quality, p.Transform, sink)
+			if err != nil {
+				log.Error("Failed to set up batch stages for pipeline", zap.String("pipeline", p.Name), zap.Error(err))
+				setExitCode(exitConfig)
+				return
+			}
+			checkers = append(checkers, checker)
+
+			sup.Add(p.Name, func(ctx context.Context) error {
+				return runPipeline(ctx, pgPool, chConn, store, p, clusterOptions(cfg), stages, loadOptions(cfg, p.BatchSize, sink))
+			})
+		}
+
+		if err := sup.Run(ctx); err != nil {
+			log.Error("Sync stopped after pipelines failed permanently", zap.Error(err))
+			setExitCode(exitFailure)
+		} else {
+			log.Success("Sync stopped")
+		}
+
+		for _, checker := range checkers {
+			printQualitySummary(checker)
+		}
+	},
+}
+
+// syncConnections shares Postgres pools, ClickHouse connections and
+// dead-letter sinks between pipelines that use the same source or
+// destination.
+type syncConnections struct {
+	deadLetter config.DeadLetterConfig
+	pools      map[string]*db.Postgres
+	chConns    map[string]*sql.DB
+	sinks      map[string]etl.DeadLetterSink
+}
+
+func newSyncConnections(dc config.DeadLetterConfig) *syncConnections {
+	return &syncConnections{
+		deadLetter: dc,
+		pools:      make(map[string]*db.Postgres),
+		chConns:    make(map[string]*sql.DB),
+		sinks:      make(map[string]etl.DeadLetterSink),
+	}
+}
+
+func (c *syncConnections) get(ctx context.Context, cfg *config.Config) (*db.Postgres, *sql.DB, etl.DeadLetterSink, error) {
+	pgDSN, chDSN := cfg.PostgresDSN(), cfg.ClickHouseDSN()
+	pgKey := pgDSN + "\x00" + cfg.PostgresReplicaDSN()
+
+	pool, ok := c.pools[pgKey]
+	if !ok {
+		var err error
+		if pool, err = db.ConnectPostgres(ctx, pgDSN, postgresOptions(cfg)); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+		}
+		c.pools[pgKey] = pool
+	}
+
+	chConn, ok := c.chConns[chDSN]
+	if !ok {
+		var err error
+		if chConn, err = db.ConnectClickhouse(ctx, chDSN, clickhouseOptions(cfg)); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to connect to ClickHouse: %w", err)
+		}
+		c.chConns[chDSN] = chConn
+	}
+
+	// a file sink is shared by all pipelines, a clickhouse sink lives in
+	// each destination
+	sinkKey := ""
+	if c.deadLetter.Type == "clickhouse" {
+		sinkKey = chDSN
+	}
+	sink, ok := c.sinks[sinkKey]
+	if !ok {
+		var err error
+		if sink, err = deadLetterSink(ctx, c.deadLetter, chConn); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to set up dead-letter sink: %w", err)
+		}
+		c.sinks[sinkKey] = sink
+	}
+
+	return pool, chConn, sink, nil
+}
+
+func (c *syncConnections) Close() {
+	for _, pool := range c.pools {
+		pool.Close()
+	}
+	for _, conn := range c.chConns {
+		conn.Close()
+	}
+}
+
+func runPipeline(ctx context.Context, pgPool *db.Postgres, chConn *sql.DB, store *checkpoint.Store, p config.PipelineConfig, cluster *etl.ClusterOptions, stages []etl.Stage, opts etl.LoadOptions) error {
+	log := log.StyledLog.With(zap.String("pipeline", p.Name), zap.String("table", p.Table))
+	ctx = events.WithPipeline(ctx, p.Table)
+
+	cols, err := etl.ProjectedColumns(ctx, pgPool, p.Table, projection(p.Columns, p.ExcludeColumns))
+	if err != nil {
+		return fmt.Errorf("failed to read schema: %w", err)
+	}
+
+	mode := etl.WriteMode(p.Mode)
+	ddlOpts, err := ddlOptions(ctx, pgPool, p.Table, mode, p.UpsertKeys, p.Polling.Deltacol, p.Types, cluster)
+	if err != nil {
+		return err
+	}
+	if ddlOpts, err = targetLayout(ddlOpts, stages); err != nil {
+		return err
+	}
+
+	targetCols, err := etl.StageSchema(cols, stages...)
+	if err != nil {
+		return err
+	}
+
+	target, err := etl.PrepareTarget(ctx, chConn, p.Target(), targetCols, mode, ddlOpts)
+	if err != nil {
+		return err
+	}
+
+	lastSeen, err := store.Load(p.Table)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Starting pipeline", zap.String("last_seen", lastSeen))
+
+	pollConfig := poller.PollConfig{
+		Table:       p.Table,
+		DeltaCol:    p.Polling.Deltacol,
+		Interval:    time.Duration(p.Polling.Interval) * time.Second,
+		Limit:       &p.Limit,
+		Projection:  projection(p.Columns, p.ExcludeColumns),
+		StartFrom:   lastSeen,
+		MaxFailures: p.Polling.MaxFailures,
+		Retry:       opts.Retry,
+		OnData: func(ctx context.Context, data *etl.TableData) error {
+			_, err := etl.LoadBatch(ctx, chConn, target.LoadTable, data, stages, opts)
+			return err
+		},
+		OnCheckpoint: func(lastSeen string) error {
+			return store.Save(p.Table, lastSeen)
+		},
+	}
+
+	return poller.NewPoller(pgPool, pollConfig).Start(ctx)
+}
+
+func init() {
+	syncCmd.Flags().StringVar(&syncConfigPath, "config", "", "Path to YAML config file (default: .pgtoch.yaml)")
+	syncCmd.Flags().StringVar(&syncPgURL, "pg-url", "", "PostgreSQL connection URL")
+	syncCmd.Flags().StringVar(&syncChURL, "ch-url", "", "ClickHouse connection URL")
+	syncCmd.Flags().StringVar(&syncStateDir, "state-dir", "", "Directory for per-table checkpoints (default: .pgtoch-state)")
+	syncCmd.Flags().StringVar(&syncPipeline, "pipeline", "", "Run only the pipeline with this name")
+	rootCmd.AddCommand(syncCmd)
+}
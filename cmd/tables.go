@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"fmt"
+	"pgtoch/config"
+	ui "pgtoch/internal/UI"
+	"pgtoch/internal/db"
+	"pgtoch/internal/etl"
+	"pgtoch/internal/log"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var tablesConfigPath, tablesPgURL string
+
+var tablesCmd = &cobra.Command{
+	Use:   "tables",
+	Short: "List postgres tables with their size, primary key and type support",
+	Run: func(cmd *cobra.Command, args []string) {
+		ui.PrintTitle("Tables")
+		ui.PrintSubtitle("discovering postgres tables")
+
+		ctx := cmd.Context()
+		log := log.StyledLog
+
+		cfg, err := readConfig(tablesConfigPath)
+		if err != nil {
+			log.Error("Failed to load config", zap.Error(err))
+			setExitCode(exitConfig)
+			return
+		}
+		if tablesPgURL != "" {
+			cfg.PostgreSQLURL = tablesPgURL
+		}
+		if cfg.PostgreSQLURL == "" && !config.PostgresFromEnv() {
+			log.Error("Missing pg_url. Provide it in YAML or with --pg-url.")
+			setExitCode(exitConfig)
+			return
+		}
+
+		conn, err := db.ConnectPostgres(ctx, cfg.PostgresDSN(), postgresOptions(cfg))
+		if err != nil {
+			log.Error("Failed to connect to PostgreSQL", zap.Error(err))
+			setExitCode(exitFailure)
+			return
+		}
+		defer conn.Close()
+
+		tables, err := etl.ListTables(ctx, conn)
+		if err != nil {
+			log.Error("Failed to list tables", zap.Error(err))
+			setExitCode(exitFailure)
+			return
+		}
+		if len(tables) == 0 {
+			log.Warn("No tables found on the search path")
+			return
+		}
+
+		var rows [][]string
+		var warnings []string
+		for _, t := range tables {
+			estimate := "not analyzed"
+			if t.RowEstimate >= 0 {
+				estimate = fmt.Sprintf("~%d", t.RowEstimate)
+			}
+			pk := strings.Join(t.PrimaryKey, ", ")
+			if pk == "" {
+				pk = "-"
+				warnings = append(warnings, t.Name+": no primary key, chunked loads and validate need one")
+			}
+			support := "all supported"
+			if unsupported := unsupportedColumns(t.Columns, cfg.Types); len(unsupported) > 0 {
+				support = fmt.Sprintf("%d unsupported", len(unsupported))
+				for _, col := range unsupported {
+					warnings = append(warnings, fmt.Sprintf("%s: column %s has type %s, which has no ClickHouse mapping", t.Name, col.Name, col.Type))
+				}
+			}
+			rows = append(rows, []string{t.Name, estimate, formatBytes(t.Size), pk, fmt.Sprintf("%d, %s", len(t.Columns), support)})
+		}
+		ui.DisplayTable([]string{"Table", "Rows (est.)", "Size", "Primary key", "Columns"}, rows)
+
+		for _, w := range warnings {
+			ui.PrintWarning(w)
+		}
+	},
+}
+
+// unsupportedColumns returns the columns that would fail to map when the
+// target table is created. Exclude them or give them a type under types.
+func unsupportedColumns(cols []etl.Column, types map[string]string) []etl.Column {
+	var unsupported []etl.Column
+	for _, col := range cols {
+		if _, ok := etl.ColumnType(col, types); !ok {
+			unsupported = append(unsupported, col)
+		}
+	}
+	return unsupported
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func init() {
+	tablesCmd.Flags().StringVar(&tablesConfigPath, "config", "", "Path to YAML config file (default: .pgtoch.yaml)")
+	tablesCmd.Flags().StringVar(&tablesPgURL, "pg-url", "", "PostgreSQL connection URL")
+	rootCmd.AddCommand(tablesCmd)
+}
@@ -0,0 +1,241 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"pgtoch/config"
+	ui "pgtoch/internal/UI"
+	"pgtoch/internal/db"
+	"pgtoch/internal/etl"
+	"pgtoch/internal/log"
+	"pgtoch/internal/validate"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var (
+	validateConfigPath, validatePgURL, validateChURL, validateTable, validateOutput, validatePipeline string
+	validateRanges, validateSamples, validateRowThreshold                                             int
+)
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Compare a postgres table with its clickhouse copy",
+	Run: func(cmd *cobra.Command, args []string) {
+		jsonOutput := validateOutput == "json"
+		if jsonOutput {
+			ui.Output = os.Stderr
+		} else {
+			ui.PrintTitle("Data Validation")
+			ui.PrintSubtitle("comparing postgres and clickhouse")
+		}
+
+		ctx := cmd.Context()
+		log := log.StyledLog
+
+		cfg, err := readConfig(validateConfigPath)
+		if err == nil {
+			cfg, err = selectPipeline(cfg, validatePipeline)
+		}
+		if err != nil {
+			log.Error("Failed to load config", zap.Error(err))
+			setExitCode(exitConfig)
+			return
+		}
+		if validatePgURL != "" {
+			cfg.PostgreSQLURL = validatePgURL
+		}
+		if validateChURL != "" {
+			cfg.ClickHouseURL = validateChURL
+		}
+		if validateTable != "" {
+			cfg.Table, cfg.TargetTable = validateTable, ""
+		}
+		if (cfg.PostgreSQLURL == "" && !config.PostgresFromEnv()) || cfg.ClickHouseURL == "" || cfg.Table == "" {
+			log.Error("Missing required config values. Provide pg_url, ch_url and table in YAML or as flags.")
+			setExitCode(exitConfig)
+			return
+		}
+
+		conn, err := db.ConnectPostgres(ctx, cfg.PostgresDSN(), postgresOptions(cfg))
+		if err != nil {
+			log.Error("Failed to connect to PostgreSQL", zap.Error(err))
+			setExitCode(exitFailure)
+			return
+		}
+		defer conn.Close()
+
+		chConn, err := db.ConnectClickhouse(ctx, cfg.ClickHouseDSN(), clickhouseOptions(cfg))
+		if err != nil {
+			log.Error("Failed to connect to ClickHouse", zap.Error(err))
+			setExitCode(exitFailure)
+			return
+		}
+		defer chConn.Close()
+
+		cols, warnings, err := validateColumns(ctx, conn, cfg)
+		if err != nil {
+			log.Error("Failed to resolve the loaded columns", zap.Error(err))
+			setExitCode(exitFailure)
+			return
+		}
+
+		report, err := validate.Run(ctx, conn, chConn, validate.Options{
+			Table:        cfg.Table,
+			Target:       cfg.Target(),
+			Columns:      cols,
+			Ranges:       validateRanges,
+			RowThreshold: validateRowThreshold,
+			Samples:      validateSamples,
+		})
+		if err != nil {
+			log.Error("Validation failed to run", zap.Error(err))
+			setExitCode(exitFailure)
+			return
+		}
+		report.Warnings = append(warnings, report.Warnings...)
+
+		if jsonOutput {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				log.Error("Failed to write report", zap.Error(err))
+				setExitCode(exitFailure)
+				return
+			}
+		} else {
+			printValidationReport(report)
+		}
+
+		if report.HasDrift() {
+			setExitCode(exitDrift)
+		}
+	},
+}
+
+// validateColumns returns the columns the table is loaded with, under their
+// ClickHouse names. Columns dropped by the transforms, or whose values they or
+// a types override change, cannot be compared and are left out with a
+// warning.
+func validateColumns(ctx context.Context, pg etl.Querier, cfg *config.Config) ([]validate.Column, []string, error) {
+	cols, err := etl.ProjectedColumns(ctx, pg, cfg.Table, projection(cfg.Columns, cfg.ExcludeColumns))
+	if err != nil {
+		return nil, nil, err
+	}
+	t, err := transformer(cfg.Table, cfg.Transform)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid transforms: %w", err)
+	}
+
+	changed := make(map[string]string)
+	for col := range cfg.Types {
+		changed[col] = "given another ClickHouse type"
+	}
+	for col := range cfg.Transform.Cast {
+		changed[col] = "cast"
+	}
+	for _, m := range cfg.Transform.Mask {
+		changed[m.Column] = "masked"
+	}
+	for _, col := range cfg.Transform.Hash {
+		changed[col] = "hashed"
+	}
+
+	var warnings []string
+	if len(cfg.Quality) > 0 {
+		warnings = append(warnings, "quality rules may have rejected rows, which then count as missing in clickhouse")
+	}
+	var out []validate.Column
+	for _, col := range cols {
+		target := col.Name
+		if t != nil {
+			name, ok := t.TargetName(col.Name)
+			if !ok {
+				warnings = append(warnings, fmt.Sprintf("column %s is dropped by the transforms and not compared", col.Name))
+				continue
+			}
+			target = name
+		}
+		if why, ok := changed[col.Name]; ok {
+			warnings = append(warnings, fmt.Sprintf("column %s is %s and not compared", col.Name, why))
+			continue
+		}
+		out = append(out, validate.Column{Column: col, Target: target})
+	}
+	if len(out) == 0 {
+		return nil, nil, fmt.Errorf("no column of %s is loaded unchanged, nothing to compare", cfg.Table)
+	}
+	return out, warnings, nil
+}
+
+func printValidationReport(report *validate.Report) {
+	ui.PrintBox("Row Counts",
+		fmt.Sprintf("Table: %s\nPostgreSQL: %d\nClickHouse: %d", report.Table, report.PostgresRows, report.ClickHouseRows))
+
+	var columnLines []string
+	for _, c := range report.Columns {
+		if !c.Match {
+			columnLines = append(columnLines, fmt.Sprintf("%s.%s: postgres %s, clickhouse %s", c.Column, c.Metric, formatMetric(c.Postgres), formatMetric(c.ClickHouse)))
+		}
+	}
+	if len(columnLines) > 0 {
+		ui.PrintBox("Column Aggregate Mismatches", strings.Join(columnLines, "\n"))
+	}
+
+	if report.RangesChecked > 0 {
+		lines := []string{fmt.Sprintf("Key: %s", strings.Join(report.Key, ", ")), fmt.Sprintf("Ranges checked: %d", report.RangesChecked)}
+		for _, r := range report.MismatchedRanges {
+			lines = append(lines, fmt.Sprintf("(%s, %s]: postgres %d rows, clickhouse %d rows", formatKey(r.After, "start"), formatKey(r.UpTo, "end"), r.PostgresRows, r.ClickHouseRows))
+		}
+		ui.PrintBox("Range Checksums", strings.Join(lines, "\n"))
+	}
+
+	if len(report.SampleRows) > 0 {
+		var lines []string
+		for _, row := range report.SampleRows {
+			lines = append(lines, fmt.Sprintf("%s: %s", formatKey(row.Key, ""), row.Kind))
+		}
+		ui.PrintBox("Sample Rows", strings.Join(lines, "\n"))
+	}
+
+	for _, w := range report.Warnings {
+		ui.PrintWarning(w)
+	}
+
+	if report.HasDrift() {
+		ui.PrintError("Drift detected between PostgreSQL and ClickHouse")
+	} else {
+		ui.PrintSuccess("PostgreSQL and ClickHouse match")
+	}
+}
+
+func formatMetric(v *float64) string {
+	if v == nil {
+		return "null"
+	}
+	return fmt.Sprintf("%g", *v)
+}
+
+func formatKey(key []string, empty string) string {
+	if key == nil {
+		return empty
+	}
+	return "(" + strings.Join(key, ", ") + ")"
+}
+
+func init() {
+	validateCmd.Flags().StringVar(&validateConfigPath, "config", "", "Path to YAML config file (default: .pgtoch.yaml)")
+	validateCmd.Flags().StringVar(&validatePgURL, "pg-url", "", "PostgreSQL connection URL")
+	validateCmd.Flags().StringVar(&validateChURL, "ch-url", "", "ClickHouse connection URL")
+	validateCmd.Flags().StringVar(&validateTable, "table", "", "Table name to validate")
+	validateCmd.Flags().StringVar(&validatePipeline, "pipeline", "", "Validate the pipeline with this name from the config")
+	validateCmd.Flags().StringVar(&validateOutput, "output", "text", "Report format: text or json")
+	validateCmd.Flags().IntVar(&validateRanges, "ranges", 16, "Primary-key ranges to checksum (0 to skip checksums)")
+	validateCmd.Flags().IntVar(&validateRowThreshold, "range-rows", 10000, "Split mismatched ranges until they hold at most this many rows")
+	validateCmd.Flags().IntVar(&validateSamples, "samples", 20, "Maximum mismatching rows to report")
+	rootCmd.AddCommand(validateCmd)
+}
@@ -2,39 +2,218 @@ package config
 
 import (
 	"errors"
+	"fmt"
+	"io/fs"
 	"os"
-
-	"gopkg.in/yaml.v3"
+	"time"
 )
 
+// CurrentVersion is the config schema version this build reads. Files without
+// a version key are treated as the current version.
+const CurrentVersion = 1
+
+var ErrNotFound = errors.New("config file not found")
+
 type Config struct {
-	PostgreSQLURL string
-	ClickHouseURL string
-	Table         string
-	Limit         int
-	BatchSize     int
-	Polling       PollingConfig
+	Version       int    `yaml:"version"`
+	PostgreSQLURL string `yaml:"pg_url"`
+	ClickHouseURL string `yaml:"ch_url"`
+	// PostgreSQLReplicaURL is a read replica that rows are extracted from.
+	// Schemas and keys are still read from pg_url.
+	PostgreSQLReplicaURL string `yaml:"pg_replica_url"`
+
+	// Credentials can be kept out of the URLs, and each one read from a
+	// file as mounted by Docker and Kubernetes secrets.
+	PostgreSQLURLFile      string `yaml:"pg_url_file"`
+	PostgreSQLPassword     string `yaml:"pg_password"`
+	PostgreSQLPasswordFile string `yaml:"pg_password_file"`
+	ClickHouseURLFile      string `yaml:"ch_url_file"`
+	ClickHousePassword     string `yaml:"ch_password"`
+	ClickHousePasswordFile string `yaml:"ch_password_file"`
+
+	Sources      map[string]ConnectionConfig `yaml:"sources"`
+	Destinations map[string]ConnectionConfig `yaml:"destinations"`
+	Postgres     PostgresConfig              `yaml:"postgres"`
+	ClickHouse   ClickHouseConfig            `yaml:"clickhouse"`
+	Cluster      ClusterConfig               `yaml:"cluster"`
+
+	Table          string            `yaml:"table"`
+	TargetTable    string            `yaml:"target_table"`
+	Columns        []string          `yaml:"columns"`
+	ExcludeColumns []string          `yaml:"exclude_columns"`
+	Limit          int               `yaml:"limit"`
+	BatchSize      int               `yaml:"batch_size"`
+	Mode           string            `yaml:"mode"`
+	UpsertKeys     []string          `yaml:"upsert_keys"`
+	ChunkSize      int               `yaml:"chunk_size"`
+	Types          map[string]string `yaml:"types"`
+	Polling        PollingConfig     `yaml:"polling"`
+	Retry          RetryConfig       `yaml:"retry"`
+	DeadLetter     DeadLetterConfig  `yaml:"dead_letter"`
+	StateDir       string            `yaml:"state_dir"`
+	Quality        []QualityRule     `yaml:"quality"`
+	Transform      TransformConfig   `yaml:"transform"`
+	Pipelines      []PipelineConfig  `yaml:"pipelines"`
+
+	file      string
+	positions map[string]position
+	// prefix locates keys of a config narrowed to one pipeline
+	prefix string
+}
+
+// ConnectionConfig is a named Postgres source or ClickHouse destination that
+// pipelines refer to.
+type ConnectionConfig struct {
+	URL          string `yaml:"url"`
+	URLFile      string `yaml:"url_file"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+	// ReplicaURL is a read replica of a source, see pg_replica_url.
+	ReplicaURL string `yaml:"replica_url"`
+}
+
+// PostgresConfig tunes every Postgres connection. Parameters in a source URL,
+// such as ?sslmode=require or ?pool_max_conns=4, take precedence.
+type PostgresConfig struct {
+	MaxConns         int32         `yaml:"max_conns"`
+	MinConns         int32         `yaml:"min_conns"`
+	ConnectTimeout   time.Duration `yaml:"connect_timeout"`
+	StatementTimeout time.Duration `yaml:"statement_timeout"`
+	ApplicationName  string        `yaml:"application_name"`
+	// ReadOnly defaults to true; pgtoch never writes to Postgres.
+	ReadOnly *bool `yaml:"read_only"`
+
+	SSLMode     string `yaml:"sslmode"`
+	SSLRootCert string `yaml:"sslrootcert"`
+	SSLCert     string `yaml:"sslcert"`
+	SSLKey      string `yaml:"sslkey"`
+}
+
+// ClickHouseConfig tunes every ClickHouse connection. Parameters in a
+// destination URL, such as ?secure=true or ?compress=zstd, take precedence.
+type ClickHouseConfig struct {
+	Secure     bool   `yaml:"secure"`
+	SkipVerify bool   `yaml:"skip_verify"`
+	CAFile     string `yaml:"ca_file"`
+	CertFile   string `yaml:"cert_file"`
+	KeyFile    string `yaml:"key_file"`
+
+	DialTimeout      time.Duration `yaml:"dial_timeout"`
+	ReadTimeout      time.Duration `yaml:"read_timeout"`
+	Compression      string        `yaml:"compression"`
+	MaxOpenConns     int           `yaml:"max_open_conns"`
+	ConnOpenStrategy string        `yaml:"connection_open_strategy"`
+
+	// for endpoints reached over http:// or https://, such as behind a proxy
+	HTTPHeaders   map[string]string `yaml:"http_headers"`
+	HTTPPath      string            `yaml:"http_path"`
+	HTTPBasicAuth bool              `yaml:"http_basic_auth"`
+}
+
+// ClusterConfig creates target tables across a ClickHouse cluster: a
+// Replicated*MergeTree table on every node behind a Distributed table.
+type ClusterConfig struct {
+	Name          string `yaml:"name"`
+	ZooKeeperPath string `yaml:"zookeeper_path"`
+	ReplicaName   string `yaml:"replica_name"`
+	ShardingKey   string `yaml:"sharding_key"`
+	// Insert is distributed (the default) or local.
+	Insert string `yaml:"insert"`
+}
+
+// PipelineConfig is one table to load. Unset values are inherited from the
+// top level of the config.
+type PipelineConfig struct {
+	Name           string            `yaml:"name"`
+	Source         string            `yaml:"source"`
+	Destination    string            `yaml:"destination"`
+	Table          string            `yaml:"table"`
+	TargetTable    string            `yaml:"target_table"`
+	Columns        []string          `yaml:"columns"`
+	ExcludeColumns []string          `yaml:"exclude_columns"`
+	Limit          int               `yaml:"limit"`
+	BatchSize      int               `yaml:"batch_size"`
+	Mode           string            `yaml:"mode"`
+	UpsertKeys     []string          `yaml:"upsert_keys"`
+	ChunkSize      int               `yaml:"chunk_size"`
+	Types          map[string]string `yaml:"types"`
+	Polling        PollingConfig     `yaml:"polling"`
+	Quality        []QualityRule     `yaml:"quality"`
+	Transform      TransformConfig   `yaml:"transform"`
 }
 
 type PollingConfig struct {
-	Enabled  bool
-	Deltacol string
-	Interval int
+	Enabled     bool   `yaml:"enabled"`
+	Deltacol    string `yaml:"delta_column"`
+	Interval    int    `yaml:"interval_seconds"`
+	MaxFailures int    `yaml:"max_failures"`
+}
+
+type RetryConfig struct {
+	MaxAttempts int           `yaml:"max_attempts"`
+	BaseDelay   time.Duration `yaml:"base_delay"`
+	MaxDelay    time.Duration `yaml:"max_delay"`
+	Jitter      *bool         `yaml:"jitter"`
+}
+
+type DeadLetterConfig struct {
+	Type      string `yaml:"type"`
+	Path      string `yaml:"path"`
+	MaxErrors int    `yaml:"max_errors"`
+}
+
+type QualityRule struct {
+	Column    string   `yaml:"column"`
+	Rule      string   `yaml:"rule"`
+	Min       *float64 `yaml:"min"`
+	Max       *float64 `yaml:"max"`
+	Pattern   string   `yaml:"pattern"`
+	Values    []string `yaml:"values"`
+	MaxLength int      `yaml:"max_length"`
+	Action    string   `yaml:"action"`
+	Default   any      `yaml:"default"`
+}
+
+type TransformConfig struct {
+	Rename   map[string]string `yaml:"rename"`
+	Drop     []string          `yaml:"drop"`
+	Cast     map[string]string `yaml:"cast"`
+	Mask     []MaskConfig      `yaml:"mask"`
+	Hash     []string          `yaml:"hash"`
+	Salt     string            `yaml:"salt"`
+	Computed []ComputedColumn  `yaml:"computed"`
+}
+
+type MaskConfig struct {
+	Column string `yaml:"column"`
+	Keep   int    `yaml:"keep"`
+}
+
+type ComputedColumn struct {
+	Name       string `yaml:"name"`
+	Expression string `yaml:"expression"`
 }
 
+// LoadConfig reads and strictly decodes a config file. Unknown keys and values
+// of the wrong type are reported as Errors with their line and column.
+// Defaults are not applied and the result is not validated, so that flags can
+// be merged in first.
 func LoadConfig(path string) (*Config, error) {
 	if path == "" {
 		path = ".pgtoch.yaml"
 	}
 
 	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, errors.New("config file not found")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, path)
 	}
-	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return nil, errors.New("cant parse config file")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	return &config, nil
+	config, err := decode(path, data)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
 }
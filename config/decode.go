@@ -0,0 +1,253 @@
+package config
+
+import (
+	"fmt"
+	"pgtoch/internal/redact"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type position struct {
+	line, column int
+}
+
+// Error is a problem with one key of the config. Line and Column are zero
+// when the key is missing from the file or was set by a flag.
+type Error struct {
+	File   string
+	Key    string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	if e.File != "" {
+		b.WriteString(e.File)
+		if e.Line > 0 {
+			fmt.Fprintf(&b, ":%d", e.Line)
+		}
+		if e.Column > 0 {
+			fmt.Fprintf(&b, ":%d", e.Column)
+		}
+		b.WriteString(": ")
+	}
+	if e.Key != "" {
+		b.WriteString(e.Key + ": ")
+	}
+	b.WriteString(e.Msg)
+	return b.String()
+}
+
+// Errors lists every problem found in a config, in file order for decoding
+// errors.
+type Errors []*Error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// decoder walks the YAML node tree alongside the Config type. yaml.v3 only
+// reports line numbers for type errors and stops at the first unknown key, so
+// the walk checks every key itself, records where each one is and expands
+// environment variables in values.
+type decoder struct {
+	file      string
+	positions map[string]position
+	errs      Errors
+}
+
+func decode(file string, data []byte) (*Config, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, syntaxError(file, err)
+	}
+
+	d := &decoder{file: file, positions: make(map[string]position)}
+	if len(root.Content) > 0 {
+		d.walk(root.Content[0], reflect.TypeOf(Config{}), "")
+	}
+	if len(d.errs) > 0 {
+		return nil, d.errs
+	}
+
+	// decode the checked and interpolated tree, not the raw bytes
+	var config Config
+	if len(root.Content) > 0 {
+		if err := root.Content[0].Decode(&config); err != nil {
+			return nil, &Error{File: file, Msg: err.Error()}
+		}
+	}
+	config.file = file
+	config.positions = d.positions
+	if err := config.readSecretFiles(); err != nil {
+		return nil, err
+	}
+	redact.AddSecret(config.PostgreSQLPassword, config.ClickHousePassword)
+	for _, conn := range config.Sources {
+		redact.AddSecret(conn.Password)
+	}
+	for _, conn := range config.Destinations {
+		redact.AddSecret(conn.Password)
+	}
+	for name, value := range config.ClickHouse.HTTPHeaders {
+		if isSecretHeader(name) {
+			redact.AddSecret(value)
+		}
+	}
+	return &config, nil
+}
+
+// isSecretHeader reports whether an HTTP header likely carries a credential,
+// such as Authorization or X-Api-Key.
+func isSecretHeader(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range []string{"auth", "token", "key", "secret", "cookie"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// syntaxError turns yaml's "yaml: line N: msg" into an Error. The parser does
+// not report a column.
+func syntaxError(file string, err error) error {
+	msg := strings.TrimPrefix(err.Error(), "yaml: ")
+	line := 0
+	if rest, ok := strings.CutPrefix(msg, "line "); ok {
+		if n, after, ok := strings.Cut(rest, ": "); ok {
+			if l, err := strconv.Atoi(n); err == nil {
+				line, msg = l, after
+			}
+		}
+	}
+	return Errors{{File: file, Line: line, Msg: msg}}
+}
+
+func (d *decoder) errorf(node *yaml.Node, key, format string, args ...any) {
+	d.errs = append(d.errs, &Error{
+		File:   d.file,
+		Key:    key,
+		Line:   node.Line,
+		Column: node.Column,
+		Msg:    fmt.Sprintf(format, args...),
+	})
+}
+
+func (d *decoder) walk(node *yaml.Node, t reflect.Type, key string) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yaml.ScalarNode && !d.expand(node, key) {
+		return
+	}
+	if node.ShortTag() == "!!null" {
+		return
+	}
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == durationType:
+		var v time.Duration
+		if node.Kind != yaml.ScalarNode || node.Decode(&v) != nil {
+			d.errorf(node, key, "expected a duration such as 500ms or 10s, got %s", describe(node))
+		}
+	case t.Kind() == reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			d.errorf(node, key, "expected a mapping, got %s", describe(node))
+			return
+		}
+		fields := make(map[string]reflect.StructField)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if name, _, _ := strings.Cut(f.Tag.Get("yaml"), ","); name != "" && name != "-" {
+				fields[name] = f
+			}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			k, v := node.Content[i], node.Content[i+1]
+			path := join(key, k.Value)
+			f, ok := fields[k.Value]
+			if !ok {
+				d.errorf(k, path, "unknown key")
+				continue
+			}
+			d.positions[path] = position{k.Line, k.Column}
+			d.walk(v, f.Type, path)
+		}
+	case t.Kind() == reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			d.errorf(node, key, "expected a mapping, got %s", describe(node))
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			k, v := node.Content[i], node.Content[i+1]
+			path := join(key, k.Value)
+			d.positions[path] = position{k.Line, k.Column}
+			d.walk(v, t.Elem(), path)
+		}
+	case t.Kind() == reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			d.errorf(node, key, "expected a list, got %s", describe(node))
+			return
+		}
+		for i, item := range node.Content {
+			path := fmt.Sprintf("%s[%d]", key, i)
+			d.positions[path] = position{item.Line, item.Column}
+			d.walk(item, t.Elem(), path)
+		}
+	case t.Kind() == reflect.Interface:
+		// default values may be any scalar
+	default:
+		if node.Kind != yaml.ScalarNode || node.Decode(reflect.New(t).Interface()) != nil {
+			d.errorf(node, key, "expected %s, got %s", kindName(t), describe(node))
+		}
+	}
+}
+
+func join(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
+
+func kindName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	default:
+		return t.String()
+	}
+}
+
+func describe(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	default:
+		return strconv.Quote(node.Value)
+	}
+}
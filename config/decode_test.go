@@ -0,0 +1,100 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{
+			name: "unknown top-level key",
+			yaml: "table: users\nbatch_sise: 10\n",
+			want: "cfg.yaml:2:1: batch_sise: unknown key",
+		},
+		{
+			name: "unknown nested key",
+			yaml: "polling:\n  enabled: true\n  interval: 5\n",
+			want: "cfg.yaml:3:3: polling.interval: unknown key",
+		},
+		{
+			name: "unknown pipeline key",
+			yaml: "pipelines:\n  - table: users\n    tabel: orders\n",
+			want: "cfg.yaml:3:5: pipelines[0].tabel: unknown key",
+		},
+		{
+			name: "wrong scalar type",
+			yaml: "table: users\nlimit: lots\n",
+			want: `cfg.yaml:2:8: limit: expected an integer, got "lots"`,
+		},
+		{
+			name: "bad duration",
+			yaml: "retry:\n  base_delay: soon\n",
+			want: `cfg.yaml:2:15: retry.base_delay: expected a duration such as 500ms or 10s, got "soon"`,
+		},
+		{
+			name: "list instead of mapping",
+			yaml: "polling:\n  - enabled\n",
+			want: "cfg.yaml:2:3: polling: expected a mapping, got a list",
+		},
+		{
+			name: "mapping instead of list",
+			yaml: "columns:\n  id: true\n",
+			want: "cfg.yaml:2:3: columns: expected a list, got a mapping",
+		},
+		{
+			name: "every problem is reported",
+			yaml: "tabel: users\nlimit: lots\n",
+			want: "cfg.yaml:1:1: tabel: unknown key\n" + `cfg.yaml:2:8: limit: expected an integer, got "lots"`,
+		},
+		{
+			name: "syntax error has a line only",
+			yaml: "table: users\n  limit: 10\n",
+			want: "cfg.yaml:2: mapping values are not allowed in this context",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decode("cfg.yaml", []byte(tt.yaml))
+			if err == nil {
+				t.Fatalf("decode succeeded, want error %q", tt.want)
+			}
+			if err.Error() != tt.want {
+				t.Errorf("error = %q, want %q", err.Error(), tt.want)
+			}
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	cfg, err := decode("cfg.yaml", []byte(`
+table: users
+limit: 50
+columns: [id, email]
+retry:
+  base_delay: 500ms
+pipelines:
+  - table: orders
+    polling:
+      enabled: true
+`))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if cfg.Table != "users" || cfg.Limit != 50 || len(cfg.Columns) != 2 {
+		t.Errorf("top-level settings = %q, %d, %v", cfg.Table, cfg.Limit, cfg.Columns)
+	}
+	if cfg.Retry.BaseDelay != 500*time.Millisecond {
+		t.Errorf("retry.base_delay = %v, want 500ms", cfg.Retry.BaseDelay)
+	}
+	if len(cfg.Pipelines) != 1 || !cfg.Pipelines[0].Polling.Enabled {
+		t.Errorf("pipelines = %+v", cfg.Pipelines)
+	}
+	if pos := cfg.positions["pipelines[0].polling"]; pos != (position{9, 5}) {
+		t.Errorf("position of pipelines[0].polling = %v, want {9 5}", pos)
+	}
+}
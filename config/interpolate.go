@@ -0,0 +1,89 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// expand interpolates ${VAR} and ${VAR:-default} in a scalar value. It runs
+// on parsed values, so a variable cannot inject YAML structure and positions
+// in errors stay accurate. It reports whether the value is usable.
+func (d *decoder) expand(node *yaml.Node, key string) bool {
+	if !strings.Contains(node.Value, "$") {
+		return true
+	}
+	value, err := expandEnv(node.Value)
+	if err != nil {
+		d.errorf(node, key, "%v", err)
+		return false
+	}
+	node.Value = value
+	if node.Style&(yaml.SingleQuotedStyle|yaml.DoubleQuotedStyle) == 0 {
+		// let the expanded text resolve to an int, bool or duration
+		node.Tag = ""
+	}
+	return true
+}
+
+// expandEnv replaces ${VAR} with the value of VAR, failing when it is unset,
+// and ${VAR:-default} with VAR or default when VAR is unset or empty. $${
+// produces a literal ${; any other $ is kept as is.
+func expandEnv(s string) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "$")
+		if i < 0 || i == len(s)-1 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		b.WriteString(s[:i])
+		s = s[i:]
+
+		switch {
+		case strings.HasPrefix(s, "$${"):
+			b.WriteString("${")
+			s = s[3:]
+		case strings.HasPrefix(s, "${"):
+			end := strings.Index(s, "}")
+			if end < 0 {
+				return "", fmt.Errorf("unterminated ${ in %q", s)
+			}
+			expr := s[2:end]
+			s = s[end+1:]
+
+			name, def, hasDefault := strings.Cut(expr, ":-")
+			if !validEnvName(name) {
+				return "", fmt.Errorf("invalid environment variable name %q", name)
+			}
+			value, ok := os.LookupEnv(name)
+			switch {
+			case hasDefault && value == "":
+				value = def
+			case !ok:
+				return "", fmt.Errorf("environment variable %s is not set", name)
+			}
+			b.WriteString(value)
+		default:
+			b.WriteByte('$')
+			s = s[1:]
+		}
+	}
+}
+
+func validEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
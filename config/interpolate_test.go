@@ -0,0 +1,108 @@
+package config
+
+import "testing"
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("PGTOCH_HOST", "db.internal")
+	t.Setenv("PGTOCH_EMPTY", "")
+
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr string
+	}{
+		{name: "no variables", in: "postgres://localhost/app", want: "postgres://localhost/app"},
+		{name: "set variable", in: "postgres://${PGTOCH_HOST}/app", want: "postgres://db.internal/app"},
+		{name: "default unused when set", in: "${PGTOCH_HOST:-localhost}", want: "db.internal"},
+		{name: "default when unset", in: "${PGTOCH_UNSET:-localhost}", want: "localhost"},
+		{name: "default when empty", in: "${PGTOCH_EMPTY:-localhost}", want: "localhost"},
+		{name: "empty default", in: "a${PGTOCH_UNSET:-}b", want: "ab"},
+		{name: "empty without default", in: "a${PGTOCH_EMPTY}b", want: "ab"},
+		{name: "escaped", in: "$${PGTOCH_HOST}", want: "${PGTOCH_HOST}"},
+		{name: "escaped next to a variable", in: "$${x}${PGTOCH_HOST}", want: "${x}db.internal"},
+		{name: "lone dollar", in: "pa$$word$", want: "pa$$word$"},
+		{name: "dollar without brace", in: "$PGTOCH_HOST", want: "$PGTOCH_HOST"},
+		{name: "unset", in: "${PGTOCH_UNSET}", wantErr: "environment variable PGTOCH_UNSET is not set"},
+		{name: "unterminated", in: "${PGTOCH_HOST", wantErr: `unterminated ${ in "${PGTOCH_HOST"`},
+		{name: "invalid name", in: "${1HOST}", wantErr: `invalid environment variable name "1HOST"`},
+		{name: "empty name", in: "${:-x}", wantErr: `invalid environment variable name ""`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandEnv(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("expandEnv(%q) error = %v, want %q", tt.in, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expandEnv(%q): %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("expandEnv(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeInterpolation(t *testing.T) {
+	t.Setenv("PGTOCH_LIMIT", "25")
+	t.Setenv("PGTOCH_TABLE", "users")
+
+	tests := []struct {
+		name    string
+		yaml    string
+		check   func(*Config) bool
+		wantErr string
+	}{
+		{
+			name:  "unquoted value resolves to an int",
+			yaml:  "limit: ${PGTOCH_LIMIT}\n",
+			check: func(c *Config) bool { return c.Limit == 25 },
+		},
+		{
+			name:  "default resolves to a bool",
+			yaml:  "polling:\n  enabled: ${PGTOCH_POLL:-true}\n",
+			check: func(c *Config) bool { return c.Polling.Enabled },
+		},
+		{
+			name:  "quoted value stays a string",
+			yaml:  "table: \"${PGTOCH_TABLE}\"\n",
+			check: func(c *Config) bool { return c.Table == "users" },
+		},
+		{
+			name:  "escaped value is kept literally",
+			yaml:  "pg_password: \"p$${w}d\"\n",
+			check: func(c *Config) bool { return c.PostgreSQLPassword == "p${w}d" },
+		},
+		{
+			name:    "unset variable is reported at the value",
+			yaml:    "table: users\nch_url: ${PGTOCH_CH_UNSET}\n",
+			wantErr: "cfg.yaml:2:9: ch_url: environment variable PGTOCH_CH_UNSET is not set",
+		},
+		{
+			name:    "expanded value is type checked",
+			yaml:    "limit: ${PGTOCH_TABLE}\n",
+			wantErr: `cfg.yaml:1:8: limit: expected an integer, got "users"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := decode("cfg.yaml", []byte(tt.yaml))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("decode error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if !tt.check(cfg) {
+				t.Errorf("decoded config = %+v", cfg)
+			}
+		})
+	}
+}
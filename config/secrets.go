@@ -0,0 +1,100 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"pgtoch/internal/quote"
+	"strings"
+)
+
+// readSecretFiles replaces each *_file setting with the contents of the file.
+// A file and its inline setting are mutually exclusive.
+func (c *Config) readSecretFiles() error {
+	var errs Errors
+	read := func(fileKey, path, key string, dst *string) {
+		if path == "" {
+			return
+		}
+		if *dst != "" {
+			errs = append(errs, c.ErrorAt(fileKey, fmt.Sprintf("cannot be combined with %s", key)))
+			return
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, c.ErrorAt(fileKey, fmt.Sprintf("failed to read secret file: %v", err)))
+			return
+		}
+		*dst = strings.TrimRight(string(data), "\r\n")
+	}
+
+	read("pg_url_file", c.PostgreSQLURLFile, "pg_url", &c.PostgreSQLURL)
+	read("pg_password_file", c.PostgreSQLPasswordFile, "pg_password", &c.PostgreSQLPassword)
+	read("ch_url_file", c.ClickHouseURLFile, "ch_url", &c.ClickHouseURL)
+	read("ch_password_file", c.ClickHousePasswordFile, "ch_password", &c.ClickHousePassword)
+
+	for _, conns := range []struct {
+		key string
+		m   map[string]ConnectionConfig
+	}{{"sources", c.Sources}, {"destinations", c.Destinations}} {
+		for name, conn := range conns.m {
+			prefix := conns.key + "." + name
+			read(prefix+".url_file", conn.URLFile, "url", &conn.URL)
+			read(prefix+".password_file", conn.PasswordFile, "password", &conn.Password)
+			conns.m[name] = conn
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// PostgresFromEnv reports whether the Postgres connection can be configured
+// by the standard PG* environment variables alone.
+func PostgresFromEnv() bool {
+	return os.Getenv("PGHOST") != "" || os.Getenv("PGSERVICE") != ""
+}
+
+// PostgresDSN returns pg_url with pg_password applied. pgx fills in whatever
+// the DSN leaves out from the PG* environment variables, and looks up a
+// missing password in PGPASSFILE or ~/.pgpass.
+func (c *Config) PostgresDSN() string {
+	return c.withPostgresPassword(c.PostgreSQLURL)
+}
+
+// PostgresReplicaDSN returns pg_replica_url with pg_password applied, or ""
+// when no replica is configured.
+func (c *Config) PostgresReplicaDSN() string {
+	if c.PostgreSQLReplicaURL == "" {
+		return ""
+	}
+	return c.withPostgresPassword(c.PostgreSQLReplicaURL)
+}
+
+func (c *Config) withPostgresPassword(dsn string) string {
+	if c.PostgreSQLPassword == "" {
+		return dsn
+	}
+	if u, err := url.Parse(dsn); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		u.User = url.UserPassword(u.User.Username(), c.PostgreSQLPassword)
+		return u.String()
+	}
+	// keyword/value DSN: a later keyword overrides an earlier one
+	return strings.TrimSpace(dsn + " password=" + quote.String(c.PostgreSQLPassword))
+}
+
+// ClickHouseDSN returns ch_url with ch_password applied.
+func (c *Config) ClickHouseDSN() string {
+	if c.ClickHousePassword == "" {
+		return c.ClickHouseURL
+	}
+	u, err := url.Parse(c.ClickHouseURL)
+	if err != nil || u.Host == "" {
+		// a bare host:port address
+		u = &url.URL{Scheme: "tcp", Host: c.ClickHouseURL}
+	}
+	u.User = url.UserPassword(u.User.Username(), c.ClickHousePassword)
+	return u.String()
+}
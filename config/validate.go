@@ -0,0 +1,419 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const (
+	DefaultLimit     = 1000
+	DefaultBatchSize = 500
+	DefaultMaxErrors = 1000
+	DefaultMode      = "append"
+)
+
+// ApplyDefaults fills unset values. The top-level settings get the defaults
+// first, then pipelines inherit the ones they leave empty, so a setting unset
+// in both gets the same default either way. The column selection, quality
+// rules, transforms and polling.enabled belong to one table and are not
+// inherited.
+func (c *Config) ApplyDefaults() {
+	if c.Version == 0 {
+		c.Version = CurrentVersion
+	}
+	if c.Limit == 0 {
+		c.Limit = DefaultLimit
+	}
+	if c.BatchSize == 0 {
+		c.BatchSize = DefaultBatchSize
+	}
+	if c.Mode == "" {
+		c.Mode = DefaultMode
+	}
+	if c.DeadLetter.MaxErrors == 0 {
+		c.DeadLetter.MaxErrors = DefaultMaxErrors
+	}
+	if c.Postgres.ReadOnly == nil {
+		readOnly := true
+		c.Postgres.ReadOnly = &readOnly
+	}
+
+	for i := range c.Pipelines {
+		p := &c.Pipelines[i]
+		if p.Name == "" {
+			p.Name = p.Table
+		}
+		if p.Source == "" && c.PostgreSQLURL == "" {
+			p.Source = onlyKey(c.Sources)
+		}
+		if p.Destination == "" && c.ClickHouseURL == "" {
+			p.Destination = onlyKey(c.Destinations)
+		}
+		if p.BatchSize == 0 {
+			p.BatchSize = c.BatchSize
+		}
+		if p.Limit == 0 {
+			p.Limit = c.Limit
+		}
+		if p.Polling.Deltacol == "" {
+			p.Polling.Deltacol = c.Polling.Deltacol
+		}
+		if p.Polling.Interval == 0 {
+			p.Polling.Interval = c.Polling.Interval
+		}
+		if p.Polling.MaxFailures == 0 {
+			p.Polling.MaxFailures = c.Polling.MaxFailures
+		}
+		if p.Mode == "" {
+			p.Mode = c.Mode
+		}
+		if len(p.UpsertKeys) == 0 {
+			p.UpsertKeys = c.UpsertKeys
+		}
+		if p.ChunkSize == 0 {
+			p.ChunkSize = c.ChunkSize
+		}
+		if len(p.Types) == 0 {
+			p.Types = c.Types
+		}
+	}
+}
+
+// Validate checks the settings shared by every command that moves data. It
+// reports all problems at once, with the file position of the offending key
+// when it came from the config file.
+func (c *Config) Validate() error {
+	var errs Errors
+	add := func(key, format string, args ...any) {
+		errs = append(errs, c.ErrorAt(key, fmt.Sprintf(format, args...)))
+	}
+
+	if c.Version != 0 && c.Version != CurrentVersion {
+		add("version", "unsupported config version %d (this build reads version %d)", c.Version, CurrentVersion)
+	}
+	if c.Table == "" && len(c.Pipelines) == 0 {
+		add("table", "is required unless pipelines are configured")
+	}
+	if c.Table != "" {
+		if c.PostgreSQLURL == "" && !PostgresFromEnv() {
+			add("pg_url", "is required unless PGHOST or PGSERVICE is set")
+		}
+		if c.ClickHouseURL == "" {
+			add("ch_url", "is required")
+		}
+		c.validateTable("", c.BatchSize, c.Polling, add)
+		if c.Polling.Enabled {
+			c.validatePolling("", c.Polling, add)
+		}
+	}
+
+	for name, conn := range c.Sources {
+		if conn.URL == "" && !PostgresFromEnv() {
+			add("sources."+name+".url", "is required unless PGHOST or PGSERVICE is set")
+		}
+	}
+	for name, conn := range c.Destinations {
+		if conn.URL == "" {
+			add("destinations."+name+".url", "is required")
+		}
+		if conn.ReplicaURL != "" {
+			add("destinations."+name+".replica_url", "only applies to sources")
+		}
+	}
+
+	if c.ChunkSize < 0 {
+		add("chunk_size", "must not be negative")
+	}
+
+	switch c.DeadLetter.Type {
+	case "", "clickhouse":
+	case "file":
+		if c.DeadLetter.Path == "" {
+			add("dead_letter.path", "is required for the file dead-letter type")
+		}
+	default:
+		add("dead_letter.type", "unknown type %q (expected file or clickhouse)", c.DeadLetter.Type)
+	}
+
+	if c.Retry.MaxAttempts < 0 {
+		add("retry.max_attempts", "must not be negative")
+	}
+	if c.Retry.BaseDelay < 0 {
+		add("retry.base_delay", "must not be negative")
+	}
+	if c.Retry.MaxDelay < 0 {
+		add("retry.max_delay", "must not be negative")
+	}
+
+	cl := c.Cluster
+	if cl.Name == "" && cl != (ClusterConfig{}) {
+		add("cluster.name", "is required when cluster is configured")
+	}
+	switch cl.Insert {
+	case "", "distributed", "local":
+	default:
+		add("cluster.insert", "unknown insert target %q (expected distributed or local)", cl.Insert)
+	}
+
+	pg := c.Postgres
+	if pg.MaxConns < 0 {
+		add("postgres.max_conns", "must not be negative")
+	}
+	if pg.MinConns < 0 {
+		add("postgres.min_conns", "must not be negative")
+	}
+	if pg.MaxConns > 0 && pg.MinConns > pg.MaxConns {
+		add("postgres.min_conns", "must not exceed postgres.max_conns")
+	}
+	if pg.ConnectTimeout < 0 {
+		add("postgres.connect_timeout", "must not be negative")
+	}
+	if pg.StatementTimeout < 0 {
+		add("postgres.statement_timeout", "must not be negative")
+	}
+	switch pg.SSLMode {
+	case "", "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+	default:
+		add("postgres.sslmode", "unknown mode %q (expected disable, allow, prefer, require, verify-ca or verify-full)", pg.SSLMode)
+	}
+	if (pg.SSLCert == "") != (pg.SSLKey == "") {
+		add("postgres.sslcert", "must be set together with postgres.sslkey")
+	}
+
+	ch := c.ClickHouse
+	if (ch.CertFile == "") != (ch.KeyFile == "") {
+		add("clickhouse.cert_file", "must be set together with clickhouse.key_file")
+	}
+	if ch.DialTimeout < 0 {
+		add("clickhouse.dial_timeout", "must not be negative")
+	}
+	if ch.ReadTimeout < 0 {
+		add("clickhouse.read_timeout", "must not be negative")
+	}
+	if ch.MaxOpenConns < 0 {
+		add("clickhouse.max_open_conns", "must not be negative")
+	}
+	switch ch.Compression {
+	case "", "none", "lz4", "zstd", "gzip", "deflate", "br":
+	default:
+		add("clickhouse.compression", "unknown compression %q (expected none, lz4 or zstd, or gzip, deflate or br over HTTP)", ch.Compression)
+	}
+	switch ch.ConnOpenStrategy {
+	case "", "in_order", "round_robin", "random":
+	default:
+		add("clickhouse.connection_open_strategy", "unknown strategy %q (expected in_order, round_robin or random)", ch.ConnOpenStrategy)
+	}
+
+	names := make(map[string]bool)
+	tables := make(map[string]bool)
+	for i, p := range c.Pipelines {
+		prefix := fmt.Sprintf("pipelines[%d]", i)
+		if p.Table == "" {
+			add(prefix+".table", "is required")
+			continue
+		}
+		if names[p.Name] {
+			add(prefix+".name", "pipeline %s is defined more than once", p.Name)
+		}
+		names[p.Name] = true
+		if tables[p.Table] {
+			add(prefix+".table", "table %s is configured in more than one pipeline", p.Table)
+		}
+		tables[p.Table] = true
+
+		switch {
+		case p.Source != "":
+			if _, ok := c.Sources[p.Source]; !ok {
+				add(prefix+".source", "unknown source %q", p.Source)
+			}
+		case c.PostgreSQLURL == "" && !PostgresFromEnv():
+			add(prefix+".source", "is required when pg_url is not set")
+		}
+		switch {
+		case p.Destination != "":
+			if _, ok := c.Destinations[p.Destination]; !ok {
+				add(prefix+".destination", "unknown destination %q", p.Destination)
+			}
+		case c.ClickHouseURL == "":
+			add(prefix+".destination", "is required when ch_url is not set")
+		}
+		if p.ChunkSize < 0 {
+			add(prefix+".chunk_size", "must not be negative")
+		}
+
+		c.validateTable(prefix, p.BatchSize, p.Polling, add)
+		if p.Polling.Enabled {
+			c.validatePolling(prefix, p.Polling, add)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// ValidateSync runs Validate and the extra checks of sync, which polls every
+// pipeline.
+func (c *Config) ValidateSync() error {
+	var errs Errors
+	if err := c.Validate(); err != nil {
+		errs = append(errs, err.(Errors)...)
+	}
+	add := func(key, format string, args ...any) {
+		errs = append(errs, c.ErrorAt(key, fmt.Sprintf(format, args...)))
+	}
+
+	if len(c.Pipelines) == 0 {
+		add("pipelines", "sync needs at least one pipeline")
+	}
+	for i, p := range c.Pipelines {
+		prefix := fmt.Sprintf("pipelines[%d]", i)
+		if p.Table == "" {
+			continue
+		}
+		if !p.Polling.Enabled {
+			c.validatePolling(prefix, p.Polling, add)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Pipeline returns a copy of the config narrowed to the named pipeline. Its
+// settings replace the top-level table settings and its source and
+// destination replace the top-level connections, so single-table commands can
+// use the result as is. A pipeline without a source or destination uses pg_url
+// or ch_url; one naming a connection that is not defined is an error. Call it
+// after ApplyDefaults.
+func (c *Config) Pipeline(name string) (*Config, error) {
+	var names []string
+	for i, p := range c.Pipelines {
+		if p.Name != name {
+			names = append(names, p.Name)
+			continue
+		}
+
+		out := *c
+		out.prefix = fmt.Sprintf("pipelines[%d]", i)
+		out.Pipelines = nil
+		out.Table = p.Table
+		out.TargetTable = p.TargetTable
+		out.Columns = p.Columns
+		out.ExcludeColumns = p.ExcludeColumns
+		out.Limit = p.Limit
+		out.BatchSize = p.BatchSize
+		out.Mode = p.Mode
+		out.UpsertKeys = p.UpsertKeys
+		out.ChunkSize = p.ChunkSize
+		out.Types = p.Types
+		out.Polling = p.Polling
+		out.Quality = p.Quality
+		out.Transform = p.Transform
+		if p.Source != "" {
+			src, ok := c.Sources[p.Source]
+			if !ok {
+				return nil, fmt.Errorf("pipeline %s: unknown source %q", name, p.Source)
+			}
+			out.PostgreSQLURL, out.PostgreSQLPassword = src.URL, src.Password
+			out.PostgreSQLReplicaURL = src.ReplicaURL
+		}
+		if p.Destination != "" {
+			dst, ok := c.Destinations[p.Destination]
+			if !ok {
+				return nil, fmt.Errorf("pipeline %s: unknown destination %q", name, p.Destination)
+			}
+			out.ClickHouseURL, out.ClickHousePassword = dst.URL, dst.Password
+		}
+		return &out, nil
+	}
+	return nil, fmt.Errorf("no pipeline named %q (configured: %s)", name, strings.Join(names, ", "))
+}
+
+// Target is the ClickHouse table the top-level table is loaded into.
+func (c *Config) Target() string {
+	if c.TargetTable != "" {
+		return c.TargetTable
+	}
+	return c.Table
+}
+
+// Target is the ClickHouse table the pipeline loads into.
+func (p PipelineConfig) Target() string {
+	if p.TargetTable != "" {
+		return p.TargetTable
+	}
+	return p.Table
+}
+
+func (c *Config) validateTable(prefix string, batchSize int, polling PollingConfig, add func(string, string, ...any)) {
+	if batchSize < 0 {
+		add(join(prefix, "batch_size"), "must not be negative")
+	}
+	if polling.MaxFailures < -1 {
+		add(join(prefix, "polling.max_failures"), "must be -1 (unlimited) or more")
+	}
+}
+
+func (c *Config) validatePolling(prefix string, polling PollingConfig, add func(string, string, ...any)) {
+	if polling.Deltacol == "" {
+		add(join(prefix, "polling.delta_column"), "is required for polling")
+	}
+	if polling.Interval <= 0 {
+		add(join(prefix, "polling.interval_seconds"), "must be greater than 0")
+	}
+}
+
+// ErrorAt builds an Error for key, located at the key itself or, when the key
+// is missing from the file, at its nearest enclosing key. Commands use it to
+// report the checks that need more than the config package.
+func (c *Config) ErrorAt(key, msg string) *Error {
+	if c.prefix != "" && pipelineKeys[strings.FieldsFunc(key, isKeySeparator)[0]] {
+		key = join(c.prefix, key)
+	}
+	e := &Error{File: c.file, Key: key, Msg: msg}
+	for k := key; k != ""; k = parent(k) {
+		if pos, ok := c.positions[k]; ok {
+			e.Line, e.Column = pos.line, pos.column
+			break
+		}
+	}
+	return e
+}
+
+// pipelineKeys are the keys a pipeline can set. Errors in a config narrowed
+// to one pipeline are reported under the pipeline for these keys.
+var pipelineKeys = func() map[string]bool {
+	keys := make(map[string]bool)
+	t := reflect.TypeOf(PipelineConfig{})
+	for i := 0; i < t.NumField(); i++ {
+		keys[t.Field(i).Tag.Get("yaml")] = true
+	}
+	return keys
+}()
+
+func isKeySeparator(r rune) bool {
+	return r == '.' || r == '['
+}
+
+func onlyKey(m map[string]ConnectionConfig) string {
+	if len(m) != 1 {
+		return ""
+	}
+	for k := range m {
+		return k
+	}
+	return ""
+}
+
+func parent(key string) string {
+	i := strings.LastIndexAny(key, ".[")
+	if i < 0 {
+		return ""
+	}
+	return key[:i]
+}
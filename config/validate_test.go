@@ -0,0 +1,107 @@
+package config
+
+import "testing"
+
+func TestPipeline(t *testing.T) {
+	base := Config{
+		PostgreSQLURL: "postgres://top/app",
+		ClickHouseURL: "clickhouse://top:9000",
+		Sources:       map[string]ConnectionConfig{"replica": {URL: "postgres://replica/app"}},
+		Destinations:  map[string]ConnectionConfig{"analytics": {URL: "clickhouse://analytics:9000"}},
+	}
+
+	tests := []struct {
+		name    string
+		p       PipelineConfig
+		wantPG  string
+		wantCH  string
+		wantErr string
+	}{
+		{
+			name:   "top-level connections",
+			p:      PipelineConfig{Name: "users", Table: "users"},
+			wantPG: "postgres://top/app",
+			wantCH: "clickhouse://top:9000",
+		},
+		{
+			name:   "named connections",
+			p:      PipelineConfig{Name: "users", Table: "users", Source: "replica", Destination: "analytics"},
+			wantPG: "postgres://replica/app",
+			wantCH: "clickhouse://analytics:9000",
+		},
+		{
+			name:    "unknown source",
+			p:       PipelineConfig{Name: "users", Table: "users", Source: "primary"},
+			wantErr: `pipeline users: unknown source "primary"`,
+		},
+		{
+			name:    "unknown destination",
+			p:       PipelineConfig{Name: "users", Table: "users", Destination: "warehouse"},
+			wantErr: `pipeline users: unknown destination "warehouse"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base
+			cfg.Pipelines = []PipelineConfig{tt.p}
+			pc, err := cfg.Pipeline(tt.p.Name)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("Pipeline error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Pipeline: %v", err)
+			}
+			if pc.PostgreSQLURL != tt.wantPG || pc.ClickHouseURL != tt.wantCH {
+				t.Errorf("connections = %s, %s, want %s, %s", pc.PostgreSQLURL, pc.ClickHouseURL, tt.wantPG, tt.wantCH)
+			}
+		})
+	}
+}
+
+func TestApplyDefaultsInheritance(t *testing.T) {
+	cfg := Config{
+		Limit:          100,
+		Mode:           "upsert",
+		UpsertKeys:     []string{"id"},
+		Columns:        []string{"id", "email"},
+		ExcludeColumns: []string{"password"},
+		Polling:        PollingConfig{Enabled: true, Deltacol: "updated_at", Interval: 30},
+		Quality:        []QualityRule{{Column: "email"}},
+		Pipelines: []PipelineConfig{
+			{Table: "orders", Mode: "append"},
+			{Table: "invoices", Limit: 10},
+		},
+	}
+	cfg.ApplyDefaults()
+	p := cfg.Pipelines[0]
+
+	bare := Config{Pipelines: []PipelineConfig{{Table: "orders"}}}
+	bare.ApplyDefaults()
+
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"name defaults to the table", p.Name == "orders"},
+		{"limit is inherited", p.Limit == 100},
+		{"batch size gets the default", p.BatchSize == DefaultBatchSize},
+		{"mode set on the pipeline is kept", p.Mode == "append"},
+		{"upsert keys are inherited", len(p.UpsertKeys) == 1},
+		{"delta column is inherited", p.Polling.Deltacol == "updated_at"},
+		{"interval is inherited", p.Polling.Interval == 30},
+		{"polling.enabled is not inherited", !p.Polling.Enabled},
+		{"columns are not inherited", p.Columns == nil && p.ExcludeColumns == nil},
+		{"quality rules are not inherited", p.Quality == nil},
+		{"limit set on the pipeline is kept", cfg.Pipelines[1].Limit == 10},
+		{"limit unset in both gets the default", bare.Pipelines[0].Limit == DefaultLimit && bare.Limit == DefaultLimit},
+		{"mode unset in both gets the default", bare.Pipelines[0].Mode == DefaultMode},
+	}
+	for _, tt := range tests {
+		if !tt.ok {
+			t.Errorf("%s: pipeline = %+v", tt.name, p)
+		}
+	}
+}
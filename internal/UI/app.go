@@ -1,7 +1,12 @@
 package ui
 
 import (
+	"context"
 	"fmt"
+	"pgtoch/internal/events"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/charmbracelet/bubbles/spinner"
@@ -19,6 +24,47 @@ const logo = `
                                                                  
 `
 
+const (
+	connectionsView = "connections"
+	tablesView      = "tables"
+	schemaView      = "schema"
+	runsView        = "runs"
+)
+
+// Connection is a source and destination pair of the config.
+type Connection struct {
+	Source      string
+	Destination string
+}
+
+func (c Connection) String() string {
+	return c.Source + " → " + c.Destination
+}
+
+type Table struct {
+	Name        string
+	RowEstimate int64 // -1 if never analyzed
+}
+
+// SchemaColumn is a source column next to the ClickHouse type it maps to,
+// empty when it has none.
+type SchemaColumn struct {
+	Name           string
+	PostgresType   string
+	ClickHouseType string
+}
+
+// Backend does the work behind the console. Ingest reports its progress
+// through the events package, tagged with the table as pipeline name.
+type Backend interface {
+	Connections() []Connection
+	Tables(ctx context.Context, conn Connection) ([]Table, error)
+	Schema(ctx context.Context, conn Connection, table string) ([]SchemaColumn, error)
+	// Ingest loads table and, when polling is configured for it, keeps
+	// polling until ctx is cancelled.
+	Ingest(ctx context.Context, conn Connection, table string) error
+}
+
 type AppModel struct {
 	Width       int
 	Height      int
@@ -27,34 +73,174 @@ type AppModel struct {
 	IsLoading   bool
 	CurrentView string
 	Error       error
+	// LogPath is shown in the footer, the console owns the terminal.
+	LogPath string
+
+	ctx         context.Context
+	cancel      context.CancelFunc
+	backend     Backend
+	events      <-chan events.Event
+	unsubscribe func()
+	// ingests tracks the backend.Ingest goroutines so Close can wait for them.
+	ingests sync.WaitGroup
+
+	connections []Connection
+	tables      []Table
+	schema      []SchemaColumn
+	conn        Connection
+	table       string
+	cursor      int
+	lastView    string
+
+	runs     map[string]*run
+	runOrder []string
+	quitting bool
+}
+
+// run is one ingest started from the console.
+type run struct {
+	table    string
+	progress *ProgressModel
+	conn     Connection
+	started  time.Time
+	total    int
+	rows     int
+	retries  int
+	done     bool
+	err      error
+
+	// poller dashboard
+	polling   bool
+	cycles    int
+	lastCycle time.Time
+	cycleRows int
+	cycleTime time.Duration
+	watermark string
+	lag       time.Duration
+	hasLag    bool
+	cycleErr  error
 }
 
-func NewAppModel() *AppModel {
+type tablesMsg struct {
+	tables []Table
+	err    error
+}
+
+type schemaMsg struct {
+	columns []SchemaColumn
+	err     error
+}
+
+type eventMsg events.Event
+
+type runDoneMsg struct {
+	table string
+	err   error
+}
+
+func NewAppModel(ctx context.Context, backend Backend) *AppModel {
 	s := spinner.New()
 	s.Spinner = spinner.Dot
 	s.Style = lipgloss.NewStyle().Foreground(lipgloss.Color("#00FF00"))
+
+	ctx, cancel := context.WithCancel(ctx)
+	ch, unsubscribe := events.Subscribe()
 	return &AppModel{
 		Spinner:     s,
-		StatusMsg:   "Ready..",
+		StatusMsg:   "Pick a connection",
 		IsLoading:   false,
-		CurrentView: "main",
+		CurrentView: connectionsView,
+		lastView:    connectionsView,
+		ctx:         ctx,
+		cancel:      cancel,
+		backend:     backend,
+		events:      ch,
+		unsubscribe: unsubscribe,
+		connections: backend.Connections(),
+		runs:        make(map[string]*run),
+	}
+}
+
+// Close stops the runs still going and the event subscription, then waits
+// up to timeout for the runs to drain so the caller can close what they use.
+// It reports whether every run finished in time.
+func (m *AppModel) Close(timeout time.Duration) bool {
+	m.cancel()
+	m.unsubscribe()
+
+	done := make(chan struct{})
+	go func() {
+		m.ingests.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
 	}
 }
 
 func (m *AppModel) Init() tea.Cmd {
-	return m.Spinner.Tick
+	return tea.Batch(m.Spinner.Tick, waitForEvent(m.events))
+}
+
+func waitForEvent(ch <-chan events.Event) tea.Cmd {
+	return func() tea.Msg {
+		e, ok := <-ch
+		if !ok {
+			return nil
+		}
+		return eventMsg(e)
+	}
 }
 
 func (m *AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
-		switch msg.String() {
-		case "ctrl+c", "q":
-			return m, tea.Quit
-		}
+		return m, m.handleKey(msg)
 	case tea.WindowSizeMsg:
 		m.Width = msg.Width
 		m.Height = msg.Height
+		return m, nil
+	case tablesMsg:
+		m.IsLoading = false
+		if msg.err != nil {
+			m.Error = msg.err
+			return m, nil
+		}
+		m.tables = msg.tables
+		m.setView(tablesView)
+		m.StatusMsg = fmt.Sprintf("%d tables on %s", len(m.tables), m.conn.Source)
+		return m, nil
+	case schemaMsg:
+		m.IsLoading = false
+		if msg.err != nil {
+			m.Error = msg.err
+			return m, nil
+		}
+		m.schema = msg.columns
+		m.setView(schemaView)
+		m.StatusMsg = "Press enter to ingest " + m.table
+		return m, nil
+	case eventMsg:
+		m.handleEvent(events.Event(msg))
+		return m, waitForEvent(m.events)
+	case runDoneMsg:
+		if r, ok := m.runs[msg.table]; ok {
+			r.done, r.err = true, msg.err
+			if msg.err == nil {
+				r.progress.SetProgress(r.rows, "Done")
+				m.StatusMsg = "Finished " + msg.table
+			} else {
+				r.progress.SetProgress(r.rows, "Failed")
+				m.StatusMsg = "Failed " + msg.table
+			}
+		}
+		if m.quitting && m.activeRuns() == 0 {
+			return m, tea.Quit
+		}
+		return m, nil
 	}
 
 	var cmd tea.Cmd
@@ -62,28 +248,215 @@ func (m *AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, cmd
 }
 
-func (m *AppModel) View() string {
-	if m.Width == 0 {
-		return "Loading..."
+func (m *AppModel) handleKey(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "ctrl+c", "q":
+		if m.activeRuns() == 0 {
+			return tea.Quit
+		}
+		// let the runs drain their batch before leaving
+		m.quitting = true
+		m.IsLoading = true
+		m.StatusMsg = "Stopping runs..."
+		m.cancel()
+		return nil
 	}
-	var view string
+	if m.quitting {
+		return nil
+	}
+
+	switch msg.String() {
+	case "up", "k":
+		if m.cursor > 0 {
+			m.cursor--
+		}
+	case "down", "j":
+		if m.cursor < m.listLen()-1 {
+			m.cursor++
+		}
+	case "tab":
+		if m.CurrentView == runsView {
+			m.setView(m.lastView)
+		} else {
+			m.lastView = m.CurrentView
+			m.setView(runsView)
+		}
+	case "esc", "backspace":
+		m.Error = nil
+		switch m.CurrentView {
+		case tablesView:
+			m.setView(connectionsView)
+		case schemaView:
+			m.setView(tablesView)
+			m.cursor = slices.IndexFunc(m.tables, func(t Table) bool { return t.Name == m.table })
+		case runsView:
+			m.setView(m.lastView)
+		}
+	case "enter":
+		if m.IsLoading {
+			return nil
+		}
+		m.Error = nil
+		return m.enter()
+	}
+	return nil
+}
+
+func (m *AppModel) enter() tea.Cmd {
 	switch m.CurrentView {
-	case "main":
-		view = m.renderMainView()
-	default:
-		view = m.renderMainView()
+	case connectionsView:
+		if len(m.connections) == 0 {
+			return nil
+		}
+		m.conn = m.connections[m.cursor]
+		m.IsLoading = true
+		m.StatusMsg = "Listing tables on " + m.conn.Source
+		ctx, backend, conn := m.ctx, m.backend, m.conn
+		return func() tea.Msg {
+			tables, err := backend.Tables(ctx, conn)
+			return tablesMsg{tables, err}
+		}
+	case tablesView:
+		if len(m.tables) == 0 {
+			return nil
+		}
+		m.table = m.tables[m.cursor].Name
+		m.IsLoading = true
+		m.StatusMsg = "Reading the schema of " + m.table
+		ctx, backend, conn, table := m.ctx, m.backend, m.conn, m.table
+		return func() tea.Msg {
+			columns, err := backend.Schema(ctx, conn, table)
+			return schemaMsg{columns, err}
+		}
+	case schemaView:
+		return m.startRun(m.conn, m.table)
 	}
+	return nil
+}
 
-	footer := FooterStyle.Render("Press q to quit")
+func (m *AppModel) startRun(conn Connection, table string) tea.Cmd {
+	if r, ok := m.runs[table]; ok && !r.done {
+		m.StatusMsg = table + " is already running"
+		return nil
+	}
+	if _, ok := m.runs[table]; !ok {
+		m.runOrder = append(m.runOrder, table)
+	}
+	m.runs[table] = &run{
+		table:    table,
+		progress: NewProgressModel(table+"  "+conn.String(), 0),
+		conn:     conn,
+		started:  time.Now(),
+	}
+	m.lastView = schemaView
+	m.setView(runsView)
+	m.StatusMsg = "Ingesting " + table
 
-	return lipgloss.JoinVertical(lipgloss.Center, view, footer)
+	ctx, backend := events.WithPipeline(m.ctx, table), m.backend
+	result := make(chan error, 1)
+	m.ingests.Add(1)
+	go func() {
+		defer m.ingests.Done()
+		result <- backend.Ingest(ctx, conn, table)
+	}()
+	return func() tea.Msg {
+		return runDoneMsg{table, <-result}
+	}
 }
 
-func (m AppModel) renderMainView() string {
-	logo := LogoStyle.Render(logo)
+func (m *AppModel) handleEvent(e events.Event) {
+	r, ok := m.runs[e.Pipeline]
+	if !ok || r.done {
+		return
+	}
+	switch e.Kind {
+	case events.Started:
+		r.total, r.started = e.Total, e.Time
+		r.progress.total = e.Total
+	case events.Inserted:
+		r.rows += e.Rows
+	case events.Retried:
+		r.retries++
+	case events.PollCycle:
+		if !r.polling {
+			// the initial load is over
+			r.total = r.rows
+			r.progress.total = r.rows
+		}
+		r.polling = true
+		r.cycles++
+		r.lastCycle, r.cycleRows, r.cycleTime = e.Time, e.Rows, e.Duration
+		r.watermark, r.cycleErr = e.Watermark, e.Err
+		r.lag, r.hasLag = e.Lag, e.Lag > 0
+	}
+	if r.total > 0 && r.rows > r.total {
+		// the estimate was short
+		r.total = r.rows
+		r.progress.total = r.rows
+	}
+	r.progress.SetProgress(r.rows, r.status())
+}
+
+func (r *run) status() string {
+	if r.polling {
+		return fmt.Sprintf("Polling, %d rows loaded", r.rows)
+	}
+	elapsed := time.Since(r.started).Seconds()
+	var rate float64
+	if elapsed > 0 {
+		rate = float64(r.rows) / elapsed
+	}
+	parts := []string{fmt.Sprintf("%.0f rows/s", rate)}
+	if r.total > 0 && rate > 0 {
+		eta := time.Duration(float64(r.total-r.rows) / rate * float64(time.Second))
+		parts = append(parts, "ETA "+eta.Round(time.Second).String())
+	}
+	if r.retries > 0 {
+		parts = append(parts, fmt.Sprintf("%d retries", r.retries))
+	}
+	return strings.Join(parts, " · ")
+}
+
+func (m *AppModel) activeRuns() int {
+	n := 0
+	for _, r := range m.runs {
+		if !r.done {
+			n++
+		}
+	}
+	return n
+}
+
+func (m *AppModel) setView(view string) {
+	m.CurrentView = view
+	m.cursor = 0
+}
+
+func (m *AppModel) listLen() int {
+	switch m.CurrentView {
+	case connectionsView:
+		return len(m.connections)
+	case tablesView:
+		return len(m.tables)
+	}
+	return 0
+}
 
-	title := TitleStyle.Render("Chug: Blazing-Fast ETL Pipeline")
-	subtitle := SubtitleStyle.Render("PostgreSQL to ClickHouse data transfer")
+func (m *AppModel) View() string {
+	if m.Width == 0 {
+		return "Loading..."
+	}
+	var view string
+	switch m.CurrentView {
+	case tablesView:
+		view = m.renderTablesView()
+	case schemaView:
+		view = m.renderSchemaView()
+	case runsView:
+		view = m.renderRunsView()
+	default:
+		view = m.renderConnectionsView()
+	}
 
 	var status string
 	if m.IsLoading {
@@ -94,33 +467,146 @@ func (m AppModel) renderMainView() string {
 		status = InfoStyle.Render(m.StatusMsg)
 	}
 
-	helpText := BoxStyle.Render(lipgloss.JoinVertical(
-		lipgloss.Left,
-		HighlightStyle.Render("Available Commands:"),
-		InfoStyle.Render("connect   - Test database connections"),
-		InfoStyle.Render("ingest    - Transfer data from PostgreSQL to ClickHouse"),
-		InfoStyle.Render("export    - Export data from ClickHouse to CSV"),
-		InfoStyle.Render("sample-config - Generate a sample configuration file"),
-	))
-
-	currentTime := time.Now().Format("15:04:05")
-	stats := BoxStyle.Render(lipgloss.JoinVertical(
-		lipgloss.Left,
-		HighlightStyle.Render("Stats:"),
-		InfoStyle.Render(fmt.Sprintf("Current time: %s", currentTime)),
-		InfoStyle.Render("Terminal size: "+fmt.Sprintf("%d×%d", m.Width, m.Height)),
-	))
-
-	boxes := lipgloss.JoinHorizontal(lipgloss.Top, helpText, stats)
-
-	return lipgloss.JoinVertical(
-		lipgloss.Center,
-		logo,
-		title,
-		subtitle,
-		"",
-		boxes,
+	help := "↑/↓ select · enter open · esc back · tab runs · q quit"
+	if m.LogPath != "" {
+		help += "\nLogs: " + m.LogPath
+	}
+	footer := FooterStyle.Render(help)
+
+	return lipgloss.JoinVertical(lipgloss.Left,
+		TitleStyle.Render("pgtoch console"),
+		view,
 		"",
 		status,
+		footer,
+	)
+}
+
+func (m *AppModel) renderConnectionsView() string {
+	if len(m.connections) == 0 {
+		return WarningStyle.Render("No connections configured, set pg_url and ch_url or sources and destinations")
+	}
+	items := make([]string, len(m.connections))
+	for i, c := range m.connections {
+		items[i] = c.String()
+	}
+	return lipgloss.JoinVertical(lipgloss.Left,
+		SubtitleStyle.Render("Connections"),
+		m.renderList(items),
 	)
 }
+
+func (m *AppModel) renderTablesView() string {
+	if len(m.tables) == 0 {
+		return WarningStyle.Render("No tables found on " + m.conn.Source)
+	}
+	width := 0
+	for _, t := range m.tables {
+		width = max(width, lipgloss.Width(t.Name))
+	}
+	items := make([]string, len(m.tables))
+	for i, t := range m.tables {
+		estimate := "not analyzed"
+		if t.RowEstimate >= 0 {
+			estimate = fmt.Sprintf("~%d rows", t.RowEstimate)
+		}
+		items[i] = fmt.Sprintf("%-*s  %s", width, t.Name, estimate)
+	}
+	return lipgloss.JoinVertical(lipgloss.Left,
+		SubtitleStyle.Render("Tables on "+m.conn.String()),
+		m.renderList(items),
+	)
+}
+
+// renderList draws items with the cursor, scrolled to fit the window.
+func (m *AppModel) renderList(items []string) string {
+	height := max(m.Height-12, 5)
+	start := 0
+	if m.cursor >= height {
+		start = m.cursor - height + 1
+	}
+	end := min(start+height, len(items))
+
+	lines := make([]string, 0, end-start)
+	for i := start; i < end; i++ {
+		if i == m.cursor {
+			lines = append(lines, HighlightStyle.Render("> "+items[i]))
+		} else {
+			lines = append(lines, InfoStyle.Render("  "+items[i]))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (m *AppModel) renderSchemaView() string {
+	rows := make([][]string, len(m.schema))
+	for i, c := range m.schema {
+		chType := c.ClickHouseType
+		if chType == "" {
+			chType = "unsupported"
+		}
+		rows[i] = []string{c.Name, c.PostgresType, chType}
+	}
+	return lipgloss.JoinVertical(lipgloss.Left,
+		SubtitleStyle.Render(m.table+" on "+m.conn.String()),
+		renderTable([]string{"Column", "PostgreSQL", "ClickHouse"}, rows),
+	)
+}
+
+func (m *AppModel) renderRunsView() string {
+	if len(m.runOrder) == 0 {
+		return InfoStyle.Render("No runs yet, pick a table and press enter on its schema")
+	}
+
+	var bars []string
+	var pollers [][]string
+	for _, table := range m.runOrder {
+		r := m.runs[table]
+		bar := r.progress.View()
+		switch {
+		case r.err != nil:
+			bar += "\n  " + ErrorStyle.Render(r.err.Error())
+		case r.done:
+			bar += "\n  " + SuccessStyle.Render(fmt.Sprintf("Loaded %d rows", r.rows))
+		}
+		bars = append(bars, bar)
+
+		if r.polling {
+			pollers = append(pollers, r.dashboardRow())
+		}
+	}
+
+	view := lipgloss.JoinVertical(lipgloss.Left, bars...)
+	if len(pollers) > 0 {
+		view = lipgloss.JoinVertical(lipgloss.Left,
+			view,
+			"",
+			SubtitleStyle.Render("Pollers"),
+			renderTable([]string{"Table", "Lag", "Last cycle", "Rows", "Took", "Watermark", "Status"}, pollers),
+		)
+	}
+	return view
+}
+
+func (r *run) dashboardRow() []string {
+	lag := "-"
+	if r.hasLag {
+		lag = r.lag.Round(time.Second).String()
+	}
+	status := "ok"
+	switch {
+	case r.cycleErr != nil:
+		status = "error: " + r.cycleErr.Error()
+	case r.done:
+		status = "stopped"
+	}
+	return []string{
+		r.table,
+		lag,
+		r.lastCycle.Format("15:04:05"),
+		fmt.Sprint(r.cycleRows),
+		r.cycleTime.Round(time.Millisecond).String(),
+		r.watermark,
+		status,
+	}
+}
@@ -2,49 +2,57 @@ package ui
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"pgtoch/internal/redact"
 	"strings"
 
 	"github.com/charmbracelet/lipgloss"
 )
 
+// Output receives everything the Print helpers write. Commands that emit
+// machine-readable output on stdout point it at os.Stderr.
+var Output io.Writer = os.Stdout
+
 func PrintLogo() {
-	fmt.Println(LogoStyle.Render(logo))
+	fmt.Fprintln(Output, LogoStyle.Render(logo))
 }
 
 func PrintTitle(title string) {
-	fmt.Println(TitleStyle.Render(title))
+	fmt.Fprintln(Output, TitleStyle.Render(title))
 }
 
 func PrintSubtitle(subtitle string) {
-	fmt.Println(SubtitleStyle.Render(subtitle))
+	fmt.Fprintln(Output, SubtitleStyle.Render(subtitle))
 }
 
 func PrintSuccess(message string) {
-	fmt.Println(SuccessStyle.Render("✓ " + message))
+	fmt.Fprintln(Output, SuccessStyle.Render("✓ "+message))
 }
 
 func PrintError(message string) {
-	fmt.Println(ErrorStyle.Render("✗ " + message))
+	fmt.Fprintln(Output, ErrorStyle.Render("✗ "+message))
 }
 
 func PrintWarning(message string) {
-	fmt.Println(WarningStyle.Render("! " + message))
+	fmt.Fprintln(Output, WarningStyle.Render("! "+message))
 }
 
 func PrintInfo(message string) {
-	fmt.Println(InfoStyle.Render(message))
+	fmt.Fprintln(Output, InfoStyle.Render(message))
 }
 
 func PrintHighlight(message string) {
-	fmt.Println(HighlightStyle.Render(message))
+	fmt.Fprintln(Output, HighlightStyle.Render(message))
 }
 
+// PrintBox masks credentials in the content, as boxes often show the
+// configuration of a run.
 func PrintBox(title string, content string) {
-	titleText := HighlightStyle.Render(title)
-	contentText := InfoStyle.Render(content)
+	titleText := HighlightStyle.Render(redact.String(title))
+	contentText := InfoStyle.Render(redact.String(content))
 	boxContent := lipgloss.JoinVertical(lipgloss.Left, titleText, contentText)
-	fmt.Println(BoxStyle.Render(boxContent))
+	fmt.Fprintln(Output, BoxStyle.Render(boxContent))
 }
 
 func ExitWithError(message string) {
@@ -52,20 +60,26 @@ func ExitWithError(message string) {
 	os.Exit(1)
 }
 
-func displayTable(headers []string, rows [][]string) {
+func DisplayTable(headers []string, rows [][]string) {
+	fmt.Fprint(Output, renderTable(headers, rows))
+}
+
+func renderTable(headers []string, rows [][]string) string {
 	colWidths := make([]int, len(headers))
 	for i, header := range headers {
-		colWidths[i] = len(header)
+		colWidths[i] = lipgloss.Width(header)
 	}
 
 	for _, row := range rows {
 		for i, cell := range row {
-			if i < len(colWidths) && len(cell) > colWidths[i] {
-				colWidths[i] = len(cell)
+			if i < len(colWidths) && lipgloss.Width(cell) > colWidths[i] {
+				colWidths[i] = lipgloss.Width(cell)
 			}
 		}
 	}
 
+	var b strings.Builder
+
 	headerCells := make([]string, len(headers))
 	for i, header := range headers {
 		headerCells[i] = TableHeaderStyle.Render(
@@ -78,14 +92,14 @@ func displayTable(headers []string, rows [][]string) {
 	}
 
 	headerRow := lipgloss.JoinHorizontal(lipgloss.Top, headerCells...)
-	fmt.Println(headerRow)
+	b.WriteString(headerRow + "\n")
 
 	separator := make([]string, len(headers))
 	for i, width := range colWidths {
-		separator[i] = strings.Repeat("─", width+2)
+		separator[i] = strings.Repeat("─", width+4)
 	}
 	separatorRow := lipgloss.JoinHorizontal(lipgloss.Top, separator...)
-	fmt.Println(HighlightStyle.Render(separatorRow))
+	b.WriteString(HighlightStyle.Render(separatorRow) + "\n")
 
 	for _, row := range rows {
 		rowCells := make([]string, len(row))
@@ -100,6 +114,7 @@ func displayTable(headers []string, rows [][]string) {
 				)
 			}
 		}
-		fmt.Println(lipgloss.JoinHorizontal(lipgloss.Top, rowCells...))
+		b.WriteString(lipgloss.JoinHorizontal(lipgloss.Top, rowCells...) + "\n")
 	}
+	return b.String()
 }
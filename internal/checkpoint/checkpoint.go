@@ -0,0 +1,78 @@
+package checkpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type Checkpoint struct {
+	Table     string    `json:"table"`
+	LastSeen  string    `json:"last_seen"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type Store struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func NewStore(dir string) (*Store, error) {
+	if dir == "" {
+		dir = ".pgtoch-state"
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create state dir: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+func (s *Store) path(table string) string {
+	return filepath.Join(s.dir, table+".checkpoint.json")
+}
+
+func (s *Store) Load(table string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.path(table))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return "", fmt.Errorf("failed to parse checkpoint for %s: %w", table, err)
+	}
+	return cp.LastSeen, nil
+}
+
+func (s *Store) Save(table, lastSeen string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.MarshalIndent(Checkpoint{
+		Table:     table,
+		LastSeen:  lastSeen,
+		UpdatedAt: time.Now().UTC(),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+
+	tmp := s.path(table) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, s.path(table)); err != nil {
+		return fmt.Errorf("failed to commit checkpoint: %w", err)
+	}
+	return nil
+}
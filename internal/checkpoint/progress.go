@@ -0,0 +1,76 @@
+package checkpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type Progress struct {
+	Table      string    `json:"table"`
+	Mode       string    `json:"mode"`
+	SchemaHash string    `json:"schema_hash"`
+	Key        []string  `json:"key"`
+	LastKey    []string  `json:"last_key,omitempty"`
+	ChunkSize  int       `json:"chunk_size"`
+	ChunksDone int       `json:"chunks_done"`
+	RowsLoaded int       `json:"rows_loaded"`
+	StartedAt  time.Time `json:"started_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func (s *Store) progressPath(table string) string {
+	return filepath.Join(s.dir, table+".progress.json")
+}
+
+func (s *Store) LoadProgress(table string) (*Progress, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.progressPath(table))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read progress: %w", err)
+	}
+
+	var p Progress
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse progress for %s: %w", table, err)
+	}
+	return &p, nil
+}
+
+func (s *Store) SaveProgress(p *Progress) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode progress: %w", err)
+	}
+
+	path := s.progressPath(p.Table)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write progress: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to commit progress: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) ClearProgress(table string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.Remove(s.progressPath(table)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to clear progress: %w", err)
+	}
+	return nil
+}
@@ -1,47 +1,207 @@
 package db
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"maps"
 	"net/url"
+	"os"
+	"pgtoch/internal/metrics"
+	"pgtoch/internal/tracing"
 	"strings"
+	"time"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
 )
 
-func ConnectClickhouse(chURL string) (*sql.DB, error) {
-	var addr string
+// ClickHouseOptions tunes a ClickHouse connection. Parameters given in the
+// DSN take precedence over these.
+type ClickHouseOptions struct {
+	Secure     bool
+	SkipVerify bool
+	CAFile     string
+	CertFile   string
+	KeyFile    string
 
-	var username, password, database string
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	Compression  string // none, lz4 or zstd natively, gzip, deflate or br over HTTP
+	MaxOpenConns int
+	// ConnOpenStrategy picks among several hosts: in_order fails over to the
+	// next host, round_robin and random spread connections across them.
+	ConnOpenStrategy string
 
-	if strings.HasPrefix(chURL, "http://") || strings.HasPrefix(chURL, "tcp://") {
-		u, err := url.Parse(chURL)
-		if err != nil {
+	// HTTP protocol only
+	HTTPHeaders   map[string]string
+	HTTPPath      string
+	HTTPBasicAuth bool
+}
+
+var compressionMethods = map[string]clickhouse.CompressionMethod{
+	"lz4":     clickhouse.CompressionLZ4,
+	"zstd":    clickhouse.CompressionZSTD,
+	"gzip":    clickhouse.CompressionGZIP,
+	"deflate": clickhouse.CompressionDeflate,
+	"br":      clickhouse.CompressionBrotli,
+}
+
+// ConnectClickhouse connects to the DSN, which may be a clickhouse://,
+// tcp://, http:// or https:// URL or a bare host:port. http:// and https://
+// use the HTTP interface, anything else the native protocol. Several hosts
+// are given comma separated, as in clickhouse://ch1:9000,ch2:9000/db.
+func ConnectClickhouse(ctx context.Context, chURL string, opts ClickHouseOptions) (conn *sql.DB, err error) {
+	ctx, span := tracing.Start(ctx, "connect clickhouse")
+	defer func() { tracing.End(span, err) }()
+
+	options, err := parseClickhouseDSN(chURL, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// OpenDB refuses pool settings in Options, they belong on the sql.DB
+	maxOpen, maxIdle, maxLifetime := options.MaxOpenConns, options.MaxIdleConns, options.ConnMaxLifetime
+	options.MaxOpenConns, options.MaxIdleConns, options.ConnMaxLifetime = 0, 0, 0
+
+	conn = clickhouse.OpenDB(options)
+	if maxOpen > 0 {
+		conn.SetMaxOpenConns(maxOpen)
+	}
+	if maxIdle > 0 {
+		conn.SetMaxIdleConns(maxIdle)
+	}
+	if maxLifetime > 0 {
+		conn.SetConnMaxLifetime(maxLifetime)
+	}
+
+	pingCtx, cancel := context.WithTimeout(tracing.ClickHouseContext(ctx), 5*time.Second)
+	defer cancel()
+
+	if err := conn.PingContext(pingCtx); err != nil {
+		metrics.ConnectionErrors.Inc()
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// parseClickhouseDSN parses the DSN with clickhouse-go and fills in what it
+// leaves out from opts.
+func parseClickhouseDSN(chURL string, opts ClickHouseOptions) (*clickhouse.Options, error) {
+	dsn := chURL
+	if !strings.Contains(dsn, "://") {
+		dsn = "clickhouse://" + dsn
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ClickHouse URL: %w", err)
+	}
+	q := u.Query()
+	if u.Scheme == "https" && !q.Has("secure") {
+		q.Set("secure", "true")
+		u.RawQuery = q.Encode()
+	}
+
+	options, err := clickhouse.ParseDSN(u.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid ClickHouse URL: %w", err)
+	}
+	options.Auth.Database = ifEmpty(options.Auth.Database, "default")
+	options.Auth.Username = ifEmpty(options.Auth.Username, "default")
+	native := options.Protocol == clickhouse.Native
+	if _, ok := options.Settings["send_logs_reveal"]; !ok && native {
+		// the HTTP interface rejects settings it does not know
+		options.Settings["send_logs_reveal"] = "trace"
+	}
+
+	if opts.Secure && !q.Has("secure") && u.Scheme != "http" {
+		options.TLS = &tls.Config{}
+	}
+	if options.TLS != nil {
+		if opts.SkipVerify && !q.Has("skip_verify") {
+			options.TLS.InsecureSkipVerify = true
+		}
+		if err := loadTLSFiles(options.TLS, opts); err != nil {
 			return nil, err
 		}
-		addr = u.Host
-		if u.User != nil {
-			username = u.User.Username()
-			password, _ = u.User.Password()
+	}
+
+	if options.DialTimeout == 0 {
+		options.DialTimeout = opts.DialTimeout
+	}
+	if options.ReadTimeout == 0 {
+		options.ReadTimeout = opts.ReadTimeout
+	}
+	if options.MaxOpenConns == 0 {
+		options.MaxOpenConns = opts.MaxOpenConns
+	}
+	if options.Compression == nil && opts.Compression != "" && opts.Compression != "none" {
+		method, ok := compressionMethods[opts.Compression]
+		if !ok {
+			return nil, fmt.Errorf("unknown ClickHouse compression %q", opts.Compression)
 		}
-		if db := strings.Trim(u.Path, "/"); db != "" {
-			database = db
+		nativeMethod := method == clickhouse.CompressionLZ4 || method == clickhouse.CompressionZSTD
+		if nativeMethod != native {
+			return nil, fmt.Errorf("%s compression is not supported over the %s protocol", opts.Compression, options.Protocol)
+		}
+		options.Compression = &clickhouse.Compression{Method: method, Level: 3}
+	}
+	if !q.Has("connection_open_strategy") {
+		switch opts.ConnOpenStrategy {
+		case "", "in_order":
+			options.ConnOpenStrategy = clickhouse.ConnOpenInOrder
+		case "round_robin":
+			options.ConnOpenStrategy = clickhouse.ConnOpenRoundRobin
+		case "random":
+			options.ConnOpenStrategy = clickhouse.ConnOpenRandom
+		default:
+			return nil, fmt.Errorf("unknown ClickHouse connection open strategy %q", opts.ConnOpenStrategy)
 		}
+	}
 
-	} else {
-		addr = chURL
+	if !native {
+		if c := options.Compression; c != nil && c.Method != clickhouse.CompressionNone {
+			// ask the server to compress responses too
+			if _, ok := options.Settings["enable_http_compression"]; !ok {
+				options.Settings["enable_http_compression"] = 1
+			}
+		}
+		options.HttpUrlPath = opts.HTTPPath
+		options.HttpHeaders = make(map[string]string, len(opts.HTTPHeaders)+1)
+		if opts.HTTPBasicAuth {
+			// clickhouse-go only sends basic auth over plain HTTP, a proxy in
+			// front of an https endpoint may need it too
+			creds := options.Auth.Username + ":" + options.Auth.Password
+			options.HttpHeaders["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(creds))
+		}
+		maps.Copy(options.HttpHeaders, opts.HTTPHeaders)
 	}
+	return options, nil
+}
 
-	return clickhouse.OpenDB(&clickhouse.Options{
-		Addr: []string{addr},
-		Auth: clickhouse.Auth{
-			Database: ifEmpty(database, "default"),
-			Username: ifEmpty(username, "default"),
-			Password: password,
-		},
-		Settings: clickhouse.Settings{
-			"send_logs_reveal": "trace",
-		},
-	}), nil
+func loadTLSFiles(cfg *tls.Config, opts ClickHouseOptions) error {
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read ClickHouse CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in ClickHouse CA file %s", opts.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load ClickHouse client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return nil
 }
 
 func ifEmpty(s, def string) string {
@@ -2,16 +2,172 @@ package db
 
 import (
 	"context"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"pgtoch/internal/etl"
+	"pgtoch/internal/metrics"
+	"pgtoch/internal/quote"
+	"pgtoch/internal/tracing"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func ConnectPostgres(pgURL string) (*pgx.Conn, error) {
+// PostgresOptions tunes the Postgres pools. Parameters given in the DSN take
+// precedence over these.
+type PostgresOptions struct {
+	MaxConns         int32
+	MinConns         int32
+	ConnectTimeout   time.Duration // default 5s
+	StatementTimeout time.Duration
+	ApplicationName  string // default pgtoch
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	SSLMode     string
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+
+	// ReadOnly makes every transaction read-only, so a misconfigured run
+	// cannot write to the source.
+	ReadOnly bool
+	// ReplicaURL, when set, serves the row reads while table metadata is
+	// read from the primary.
+	ReplicaURL string
+}
+
+// Postgres is a pooled Postgres connection. It is an etl.Querier reading from
+// the replica when one is configured, and provides the primary for schema and
+// key discovery.
+type Postgres struct {
+	Pool    *pgxpool.Pool
+	Primary *pgxpool.Pool
+}
+
+func (p *Postgres) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return p.Pool.Query(ctx, sql, args...)
+}
+
+// Metadata returns the primary, see etl.MetadataSource.
+func (p *Postgres) Metadata() etl.Querier {
+	return p.Primary
+}
+
+func (p *Postgres) Close() {
+	if p.Primary != p.Pool {
+		p.Primary.Close()
+	}
+	p.Pool.Close()
+}
+
+func ConnectPostgres(ctx context.Context, pgURL string, opts PostgresOptions) (pg *Postgres, err error) {
+	ctx, span := tracing.Start(ctx, "connect postgres")
+	defer func() { tracing.End(span, err) }()
+
+	primary, err := connectPool(ctx, pgURL, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.ReplicaURL == "" {
+		return &Postgres{Pool: primary, Primary: primary}, nil
+	}
+
+	replica, err := connectPool(ctx, opts.ReplicaURL, opts)
+	if err != nil {
+		primary.Close()
+		return nil, fmt.Errorf("replica: %w", err)
+	}
+	return &Postgres{Pool: replica, Primary: primary}, nil
+}
+
+func connectPool(ctx context.Context, pgURL string, opts PostgresOptions) (*pgxpool.Pool, error) {
+	timeout := opts.ConnectTimeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	return pgx.Connect(ctx, pgURL)
+	poolConfig, err := pgxpool.ParseConfig(postgresDSN(pgURL, opts))
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := pool.Ping(ctx); err != nil {
+		metrics.ConnectionErrors.Inc()
+		pool.Close()
+		return nil, err
+	}
+	return pool, nil
+}
+
+// postgresDSN adds the options the DSN does not set itself as parameters,
+// which pgx turns into pool, TLS and session settings.
+func postgresDSN(pgURL string, opts PostgresOptions) string {
+	params := make(map[string]string)
+	if opts.MaxConns > 0 {
+		params["pool_max_conns"] = strconv.Itoa(int(opts.MaxConns))
+	}
+	if opts.MinConns > 0 {
+		params["pool_min_conns"] = strconv.Itoa(int(opts.MinConns))
+	}
+	if opts.ConnectTimeout > 0 {
+		params["connect_timeout"] = strconv.Itoa(int(max(opts.ConnectTimeout.Round(time.Second), time.Second).Seconds()))
+	}
+	if opts.StatementTimeout > 0 {
+		params["statement_timeout"] = strconv.FormatInt(opts.StatementTimeout.Milliseconds(), 10)
+	}
+	if os.Getenv("PGAPPNAME") == "" {
+		params["application_name"] = ifEmpty(opts.ApplicationName, "pgtoch")
+	}
+	if opts.SSLMode != "" {
+		params["sslmode"] = opts.SSLMode
+	}
+	if opts.SSLRootCert != "" {
+		params["sslrootcert"] = opts.SSLRootCert
+	}
+	if opts.SSLCert != "" {
+		params["sslcert"] = opts.SSLCert
+	}
+	if opts.SSLKey != "" {
+		params["sslkey"] = opts.SSLKey
+	}
+	if opts.ReadOnly {
+		params["default_transaction_read_only"] = "on"
+	}
+
+	if u, err := url.Parse(pgURL); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		q := u.Query()
+		for k, v := range params {
+			if !q.Has(k) {
+				q.Set(k, v)
+			}
+		}
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+
+	// keyword/value DSN
+	dsn := pgURL
+	for _, k := range slices.Sorted(maps.Keys(params)) {
+		if !hasKeyword(pgURL, k) {
+			dsn += " " + k + "=" + quote.String(params[k])
+		}
+	}
+	return strings.TrimSpace(dsn)
+}
 
+func hasKeyword(dsn, key string) bool {
+	return regexp.MustCompile(`(^|\s)` + regexp.QuoteMeta(key) + `\s*=`).MatchString(dsn)
 }
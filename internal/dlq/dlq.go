@@ -0,0 +1,239 @@
+package dlq
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"pgtoch/internal/etl"
+	"strings"
+	"sync"
+	"time"
+)
+
+const TableName = "_pgtoch_dlq"
+
+type Record struct {
+	ID       string    `json:"id,omitempty"`
+	Table    string    `json:"table"`
+	Columns  []string  `json:"columns"`
+	Row      []any     `json:"row"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+func newRecords(table string, columns []string, rejected []etl.RejectedRow) []Record {
+	now := time.Now().UTC()
+	records := make([]Record, len(rejected))
+	for i, r := range rejected {
+		records[i] = Record{
+			Table:    table,
+			Columns:  columns,
+			Row:      r.Row,
+			Error:    r.Err.Error(),
+			FailedAt: now,
+		}
+	}
+	return records
+}
+
+type FileSink struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
+
+func (s *FileSink) Write(ctx context.Context, table string, columns []string, rejected []etl.RejectedRow) error {
+	return s.WriteRecords(newRecords(table, columns, rejected))
+}
+
+func (s *FileSink) WriteRecords(records []Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open dead-letter file: %w", err)
+	}
+	defer file.Close()
+
+	enc := json.NewEncoder(file)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			return fmt.Errorf("failed to write dead-letter record: %w", err)
+		}
+	}
+	return file.Sync()
+}
+
+// RewriteFile replaces the dead-letter file at path with records, or removes
+// it when there are none left.
+func RewriteFile(path string, records []Record) error {
+	if len(records) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove dead-letter file: %w", err)
+		}
+		return nil
+	}
+
+	tmp := path + ".tmp"
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale dead-letter file: %w", err)
+	}
+	if err := NewFileSink(tmp).WriteRecords(records); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace dead-letter file: %w", err)
+	}
+	return nil
+}
+
+func ReadFile(path string) ([]Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dead-letter file: %w", err)
+	}
+	defer file.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		record, err := decodeRecord(scanner.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read dead-letter file: %w", err)
+	}
+	return records, nil
+}
+
+func decodeRecord(data []byte) (Record, error) {
+	var record Record
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&record); err != nil {
+		return record, fmt.Errorf("invalid dead-letter record: %w", err)
+	}
+	return record, nil
+}
+
+type ClickHouseSink struct {
+	conn *sql.DB
+}
+
+func NewClickHouseSink(ctx context.Context, conn *sql.DB) (*ClickHouseSink, error) {
+	ddl := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id UUID DEFAULT generateUUIDv4(),
+		failed_at DateTime64(3),
+		table String,
+		columns Array(String),
+		row String,
+		error String
+	) ENGINE = MergeTree() ORDER BY (table, failed_at)`, etl.QuoteIdentifier(TableName))
+
+	if _, err := conn.ExecContext(ctx, ddl); err != nil {
+		return nil, fmt.Errorf("failed to create dead-letter table: %w", err)
+	}
+	return &ClickHouseSink{conn: conn}, nil
+}
+
+func (s *ClickHouseSink) Write(ctx context.Context, table string, columns []string, rejected []etl.RejectedRow) error {
+	records := newRecords(table, columns, rejected)
+
+	query := fmt.Sprintf("INSERT INTO %s (failed_at, table, columns, row, error) VALUES", etl.QuoteIdentifier(TableName))
+	var args []any
+	for i, record := range records {
+		row, err := json.Marshal(record.Row)
+		if err != nil {
+			return fmt.Errorf("failed to encode rejected row: %w", err)
+		}
+		if i > 0 {
+			query += ","
+		}
+		query += " (?, ?, ?, ?, ?)"
+		args = append(args, record.FailedAt, record.Table, record.Columns, string(row), record.Error)
+	}
+
+	if _, err := s.conn.ExecContext(context.WithoutCancel(ctx), query, args...); err != nil {
+		return fmt.Errorf("failed to insert into %s: %w", TableName, err)
+	}
+	return nil
+}
+
+func ReadTable(ctx context.Context, conn *sql.DB, table string) ([]Record, error) {
+	query := fmt.Sprintf("SELECT toString(id), failed_at, table, columns, row, error FROM %s", etl.QuoteIdentifier(TableName))
+	var args []any
+	if table != "" {
+		query += " WHERE table = ?"
+		args = append(args, table)
+	}
+	query += " ORDER BY failed_at"
+
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", TableName, err)
+	}
+	defer rows.Close()
+
+	var records []Record
+	for rows.Next() {
+		var record Record
+		var row string
+		if err := rows.Scan(&record.ID, &record.FailedAt, &record.Table, &record.Columns, &row, &record.Error); err != nil {
+			return nil, fmt.Errorf("failed to scan dead-letter record: %w", err)
+		}
+		dec := json.NewDecoder(strings.NewReader(row))
+		dec.UseNumber()
+		if err := dec.Decode(&record.Row); err != nil {
+			return nil, fmt.Errorf("invalid row in dead-letter record %s: %w", record.ID, err)
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func DeleteFromTable(ctx context.Context, conn *sql.DB, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	query := fmt.Sprintf("ALTER TABLE %s DELETE WHERE has(?, toString(id))", etl.QuoteIdentifier(TableName))
+	if _, err := conn.ExecContext(ctx, query, ids); err != nil {
+		return fmt.Errorf("failed to delete replayed records: %w", err)
+	}
+	return nil
+}
+
+// UpdateErrors records the error the records failed with on their last
+// replay. failed_at is part of the sorting key and keeps the first failure.
+func UpdateErrors(ctx context.Context, conn *sql.DB, records []Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	ids := make([]string, len(records))
+	errs := make([]string, len(records))
+	for i, r := range records {
+		ids[i], errs[i] = r.ID, r.Error
+	}
+	query := fmt.Sprintf("ALTER TABLE %s UPDATE error = transform(toString(id), ?, ?, error) WHERE has(?, toString(id))", etl.QuoteIdentifier(TableName))
+	if _, err := conn.ExecContext(ctx, query, ids, errs, ids); err != nil {
+		return fmt.Errorf("failed to update records still failing: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package dlq
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"pgtoch/internal/etl"
+	"strings"
+	"time"
+)
+
+type ReplayResult struct {
+	Table    string
+	Replayed int
+	Failed   int
+	// ReplayedIDs are the records that made it into the table, Rejected
+	// those that failed again, with their new error.
+	ReplayedIDs []string
+	Rejected    []Record
+}
+
+func GroupByTable(records []Record) map[string][]Record {
+	groups := make(map[string][]Record)
+	for _, record := range records {
+		key := record.Table + "(" + strings.Join(record.Columns, ",") + ")"
+		groups[key] = append(groups[key], record)
+	}
+	return groups
+}
+
+// Replay inserts the records one batch at a time, so that when it fails
+// part way the result still tells exactly which records were inserted.
+// Rows rejected again are passed on to opts.DeadLetter when it is set.
+func Replay(ctx context.Context, conn *sql.DB, records []Record, opts etl.LoadOptions) (ReplayResult, error) {
+	if len(records) == 0 {
+		return ReplayResult{}, nil
+	}
+	table := records[0].Table
+	columns := records[0].Columns
+	result := ReplayResult{Table: table}
+
+	types, err := columnTypes(ctx, conn, table)
+	if err != nil {
+		return result, err
+	}
+
+	rows := make([][]any, len(records))
+	for i, record := range records {
+		if len(record.Row) != len(columns) {
+			return result, fmt.Errorf("dead-letter record for %s has %d values for %d columns", table, len(record.Row), len(columns))
+		}
+		row := make([]any, len(record.Row))
+		for j, v := range record.Row {
+			row[j] = restoreValue(v, types[columns[j]])
+		}
+		rows[i] = row
+	}
+
+	sink := &replaySink{next: opts.DeadLetter, records: make(map[*any]Record, len(records))}
+	for i, row := range rows {
+		sink.records[&row[0]] = records[i]
+	}
+	opts.DeadLetter = sink
+
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = len(rows)
+	}
+	for start := 0; start < len(rows); start += batchSize {
+		end := min(start+batchSize, len(rows))
+		sink.failed = make(map[*any]bool)
+		stats, err := etl.InsertRows(ctx, conn, table, columns, rows[start:end], opts)
+		result.Replayed += stats.Inserted
+		result.Failed += stats.Rejected
+		result.Rejected = sink.rejected
+		if err != nil {
+			return result, err
+		}
+		for i := start; i < end; i++ {
+			if !sink.failed[&rows[i][0]] {
+				result.ReplayedIDs = append(result.ReplayedIDs, records[i].ID)
+			}
+		}
+	}
+	return result, nil
+}
+
+// replaySink maps rejected rows back to the records they came from.
+type replaySink struct {
+	next     etl.DeadLetterSink
+	records  map[*any]Record // by the first value of their row
+	failed   map[*any]bool   // in the current batch
+	rejected []Record
+}
+
+func (s *replaySink) Write(ctx context.Context, table string, columns []string, rejected []etl.RejectedRow) error {
+	// a row only counts as rejected once it is safe in the next sink
+	if s.next != nil {
+		if err := s.next.Write(ctx, table, columns, rejected); err != nil {
+			return err
+		}
+	}
+	for _, r := range rejected {
+		record := s.records[&r.Row[0]]
+		record.Error = r.Err.Error()
+		record.FailedAt = time.Now().UTC()
+		s.rejected = append(s.rejected, record)
+		s.failed[&r.Row[0]] = true
+	}
+	return nil
+}
+
+func columnTypes(ctx context.Context, conn *sql.DB, table string) (map[string]string, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT name, type FROM system.columns WHERE database = currentDatabase() AND table = ?", table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	types := make(map[string]string)
+	for rows.Next() {
+		var name, typ string
+		if err := rows.Scan(&name, &typ); err != nil {
+			return nil, fmt.Errorf("failed to scan column type: %w", err)
+		}
+		if inner, ok := strings.CutPrefix(typ, "Nullable("); ok {
+			typ = strings.TrimSuffix(inner, ")")
+		}
+		types[name] = typ
+	}
+	if len(types) == 0 {
+		return nil, fmt.Errorf("table %s does not exist in clickhouse", table)
+	}
+	return types, rows.Err()
+}
+
+func restoreValue(v any, chType string) any {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case string:
+		if strings.HasPrefix(chType, "Date") {
+			if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				return t
+			}
+		}
+		return v
+	case map[string]any, []any:
+		data, _ := json.Marshal(v)
+		return string(data)
+	default:
+		return v
+	}
+}
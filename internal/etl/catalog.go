@@ -0,0 +1,146 @@
+package etl
+
+import (
+	"context"
+	"fmt"
+	"pgtoch/internal/tracing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// TableInfo describes a table found in the source database.
+type TableInfo struct {
+	Name string
+	// RowEstimate comes from the planner statistics, -1 when the table was
+	// never analyzed.
+	RowEstimate int64
+	Size        int64 // bytes, indexes and TOAST included
+	PrimaryKey  []string
+	Columns     []Column
+}
+
+// ListTables returns the ordinary and partitioned tables visible on the
+// search path, in name order.
+func ListTables(ctx context.Context, conn Querier) (tables []TableInfo, err error) {
+	ctx, span := tracing.Start(ctx, "list tables")
+	defer func() { tracing.End(span, err) }()
+
+	query := `
+	SELECT c.relname, c.reltuples::bigint, pg_total_relation_size(c.oid)
+	FROM pg_class c
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE c.relkind IN ('r', 'p')
+		AND pg_table_is_visible(c.oid)
+		AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+	ORDER BY c.relname
+	`
+	rows, err := metadata(conn).Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t TableInfo
+		if err := rows.Scan(&t.Name, &t.RowEstimate, &t.Size); err != nil {
+			return nil, fmt.Errorf("failed to scan table: %w", err)
+		}
+		tables = append(tables, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	names := make([]string, len(tables))
+	byName := make(map[string]*TableInfo, len(tables))
+	for i := range tables {
+		names[i] = tables[i].Name
+		byName[tables[i].Name] = &tables[i]
+	}
+
+	if err := listColumns(ctx, conn, names, byName); err != nil {
+		return nil, err
+	}
+	if err := listPrimaryKeys(ctx, conn, byName); err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
+// listColumns reads the columns of every table at once, the way getColumns
+// reads those of one. Only the tables ListTables found are read, not
+// same-named tables in schemas further down the search path.
+func listColumns(ctx context.Context, conn Querier, names []string, byName map[string]*TableInfo) error {
+	query := `
+	SELECT col.table_name, col.column_name, col.data_type
+	FROM information_schema.columns col
+	JOIN pg_namespace n ON n.nspname = col.table_schema
+	JOIN pg_class c ON c.relnamespace = n.oid AND c.relname = col.table_name
+	WHERE col.table_name = ANY($1)
+		AND c.relkind IN ('r', 'p')
+		AND pg_table_is_visible(c.oid)
+	ORDER BY col.table_name, col.ordinal_position
+	`
+	rows, err := metadata(conn).Query(ctx, query, names)
+	if err != nil {
+		return fmt.Errorf("failed to query columns: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var table string
+		var col Column
+		if err := rows.Scan(&table, &col.Name, &col.Type); err != nil {
+			return fmt.Errorf("failed to scan column: %w", err)
+		}
+		if t, ok := byName[table]; ok {
+			t.Columns = append(t.Columns, col)
+		}
+	}
+	return rows.Err()
+}
+
+func listPrimaryKeys(ctx context.Context, conn Querier, byName map[string]*TableInfo) error {
+	query := `
+	SELECT c.relname, a.attname
+	FROM pg_index i
+	JOIN pg_class c ON c.oid = i.indrelid
+	JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+	WHERE i.indisprimary AND pg_table_is_visible(c.oid)
+	ORDER BY c.relname, array_position(i.indkey::int2[], a.attnum)
+	`
+	rows, err := metadata(conn).Query(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to query primary keys: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var table, key string
+		if err := rows.Scan(&table, &key); err != nil {
+			return fmt.Errorf("failed to scan primary key column: %w", err)
+		}
+		if t, ok := byName[table]; ok {
+			t.PrimaryKey = append(t.PrimaryKey, key)
+		}
+	}
+	return rows.Err()
+}
+
+// EstimateRows returns the planner's row estimate for table, -1 when it was
+// never analyzed. table is quoted before the regclass cast, so mixed-case
+// names resolve like in the extract queries.
+func EstimateRows(ctx context.Context, conn Querier, table string) (int64, error) {
+	rows, err := metadata(conn).Query(ctx, "SELECT reltuples::bigint FROM pg_class WHERE oid = $1::regclass", pgx.Identifier{table}.Sanitize())
+	if err != nil {
+		return 0, fmt.Errorf("failed to estimate rows of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	estimate := int64(-1)
+	if rows.Next() {
+		if err := rows.Scan(&estimate); err != nil {
+			return 0, fmt.Errorf("failed to estimate rows of %s: %w", table, err)
+		}
+	}
+	return estimate, rows.Err()
+}
@@ -0,0 +1,114 @@
+package etl
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"pgtoch/internal/events"
+	"pgtoch/internal/metrics"
+	"pgtoch/internal/tracing"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func SchemaFingerprint(cols []Column) string {
+	h := sha256.New()
+	for _, col := range cols {
+		fmt.Fprintf(h, "%s:%s\n", col.Name, col.Type)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func ExtractChunk(ctx context.Context, conn Querier, table string, cols []Column, key []string, after []string, size int) (td *TableData, err error) {
+	ctx, span := tracing.Start(ctx, "extract chunk", tracing.Table(table))
+	defer func() { endExtractSpan(span, td, err) }()
+
+	keyTypes := make(map[string]string, len(cols))
+	for _, col := range cols {
+		keyTypes[col.Name] = col.Type
+	}
+
+	quotedKey := make([]string, len(key))
+	for i, k := range key {
+		if _, ok := keyTypes[k]; !ok {
+			return nil, fmt.Errorf("key column %s is not among the selected columns of %s", k, table)
+		}
+		quotedKey[i] = pgx.Identifier{k}.Sanitize()
+	}
+	keyList := strings.Join(quotedKey, ", ")
+
+	var args []any
+	query := "SELECT " + selectList(cols) + " FROM " + pgx.Identifier{table}.Sanitize()
+	if len(after) > 0 {
+		if len(after) != len(key) {
+			return nil, fmt.Errorf("resume position has %d values for a %d column key", len(after), len(key))
+		}
+		placeholders := make([]string, len(after))
+		for i, v := range after {
+			args = append(args, v)
+			placeholders[i] = fmt.Sprintf("$%d::text::%s", len(args), keyTypes[key[i]])
+		}
+		query += fmt.Sprintf(" WHERE (%s) > (%s)", keyList, strings.Join(placeholders, ", "))
+	}
+	query += fmt.Sprintf(" ORDER BY %s", keyList)
+	if size > 0 {
+		args = append(args, size)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query chunk: %w", err)
+	}
+	defer rows.Close()
+
+	results, err := scanRows(rows, cols)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.RowsExtracted.WithLabelValues(events.Pipeline(ctx, table)).Add(float64(len(results)))
+	events.Publish(ctx, table, events.Event{Kind: events.Extracted, Rows: len(results)})
+
+	return &TableData{
+		Columns: cols,
+		Rows:    results,
+	}, nil
+}
+
+func KeyValuesAt(td *TableData, key []string, row int) ([]string, error) {
+	values := make([]string, len(key))
+	for i, k := range key {
+		v, err := DeltaValueAt(td, k, row)
+		if err != nil {
+			return nil, fmt.Errorf("key column %s not found", k)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+func MaxDeltaValue(ctx context.Context, conn Querier, table, deltaCol string) (string, error) {
+	query := fmt.Sprintf("SELECT max(%s) FROM %s", pgx.Identifier{deltaCol}.Sanitize(), pgx.Identifier{table}.Sanitize())
+	rows, err := conn.Query(ctx, query)
+	if err != nil {
+		return "", fmt.Errorf("failed to query max %s: %w", deltaCol, err)
+	}
+	defer rows.Close()
+
+	var value any
+	if rows.Next() {
+		if err := rows.Scan(&value); err != nil {
+			return "", fmt.Errorf("failed to scan max %s: %w", deltaCol, err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if value == nil {
+		return "", nil
+	}
+	return FormatDeltaValue(value), nil
+}
@@ -0,0 +1,102 @@
+package etl
+
+import (
+	"fmt"
+	"pgtoch/internal/quote"
+	"strings"
+)
+
+const (
+	// DefaultZooKeeperPath keeps each shard's replicas of a table together,
+	// relying on the {shard} macro of every node.
+	DefaultZooKeeperPath = "/clickhouse/tables/{shard}/{database}/{table}"
+	DefaultReplicaName   = "{replica}"
+
+	localSuffix = "_local"
+)
+
+// ClusterOptions lays a table out over a ClickHouse cluster: a
+// Replicated*MergeTree table named <table>_local on every node, and a
+// Distributed table under the target name that reads from and shards
+// inserts over all of them. DDL runs ON CLUSTER.
+type ClusterOptions struct {
+	Name          string
+	ZooKeeperPath string // default DefaultZooKeeperPath
+	ReplicaName   string // default DefaultReplicaName
+	// ShardingKey spreads inserts into the Distributed table over the
+	// shards. It defaults to a hash of the upsert keys, so duplicates meet on
+	// one shard, and to rand() otherwise.
+	ShardingKey string
+	// InsertLocal loads into the local table of the node connected to
+	// instead of the Distributed table, leaving the spread over shards to
+	// the client, such as a URL listing one host per shard.
+	InsertLocal bool
+}
+
+func LocalTableName(table string) string {
+	return table + localSuffix
+}
+
+// LoadTable is the table rows for table are inserted into.
+func (c *ClusterOptions) LoadTable(table string) string {
+	if c != nil && c.InsertLocal {
+		return LocalTableName(table)
+	}
+	return table
+}
+
+func (c *ClusterOptions) onCluster() string {
+	if c == nil {
+		return ""
+	}
+	return " ON CLUSTER " + QuoteIdentifier(c.Name)
+}
+
+// BuildClusterDDL returns the statements creating the local table on every
+// node and the Distributed table over it.
+func BuildClusterDDL(table string, cols []Column, opts DDLOptions) ([]string, error) {
+	c := opts.Cluster
+	if c == nil || c.Name == "" {
+		return nil, fmt.Errorf("no cluster set for %s", table)
+	}
+	mappedCols, err := mapColumns(cols, opts.Types)
+	if err != nil {
+		return nil, err
+	}
+	if len(mappedCols) == 0 {
+		return nil, fmt.Errorf("no columns to create table")
+	}
+
+	zkPath, replica := c.ZooKeeperPath, c.ReplicaName
+	if zkPath == "" {
+		zkPath = DefaultZooKeeperPath
+	}
+	if replica == "" {
+		replica = DefaultReplicaName
+	}
+	engine := opts.Engine
+	if engine == "" {
+		engine = "MergeTree"
+	}
+	engine = fmt.Sprintf("Replicated%s(%s)", engine, engineArgs([]string{quote.String(zkPath), quote.String(replica)}, opts.Version))
+
+	shardingKey := c.ShardingKey
+	if shardingKey == "" {
+		shardingKey = "rand()"
+		if opts.Engine == "ReplacingMergeTree" && len(opts.OrderBy) > 0 {
+			quoted := make([]string, len(opts.OrderBy))
+			for i, col := range opts.OrderBy {
+				quoted[i] = QuoteIdentifier(col)
+			}
+			shardingKey = "cityHash64(" + strings.Join(quoted, ", ") + ")"
+		}
+	}
+
+	local := LocalTableName(table)
+	return []string{
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s%s (%s) ENGINE = %s ORDER BY %s;",
+			QuoteIdentifier(local), c.onCluster(), strings.Join(mappedCols, ", "), engine, orderByClause(opts.OrderBy)),
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s%s AS %s ENGINE = Distributed(%s, currentDatabase(), %s, %s);",
+			QuoteIdentifier(table), c.onCluster(), QuoteIdentifier(local), quote.String(c.Name), quote.String(local), shardingKey),
+	}, nil
+}
@@ -0,0 +1,95 @@
+package etl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildClusterDDL(t *testing.T) {
+	cols := []Column{{Name: "id", Type: "integer"}, {Name: "email", Type: "text"}}
+
+	tests := []struct {
+		name    string
+		opts    DDLOptions
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "defaults",
+			opts: DDLOptions{Cluster: &ClusterOptions{Name: "main"}},
+			want: []string{
+				`CREATE TABLE IF NOT EXISTS "users_local" ON CLUSTER "main" (id Int32, email String) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/{database}/{table}', '{replica}') ORDER BY tuple();`,
+				`CREATE TABLE IF NOT EXISTS "users" ON CLUSTER "main" AS "users_local" ENGINE = Distributed('main', currentDatabase(), 'users_local', rand());`,
+			},
+		},
+		{
+			name: "upsert shards on the keys",
+			opts: DDLOptions{
+				Engine:  "ReplacingMergeTree",
+				OrderBy: []string{"id"},
+				Version: "updated_at",
+				Cluster: &ClusterOptions{Name: "main"},
+			},
+			want: []string{
+				`CREATE TABLE IF NOT EXISTS "users_local" ON CLUSTER "main" (id Int32, email String) ENGINE = ReplicatedReplacingMergeTree('/clickhouse/tables/{shard}/{database}/{table}', '{replica}', "updated_at") ORDER BY ("id");`,
+				`CREATE TABLE IF NOT EXISTS "users" ON CLUSTER "main" AS "users_local" ENGINE = Distributed('main', currentDatabase(), 'users_local', cityHash64("id"));`,
+			},
+		},
+		{
+			name: "custom paths and sharding key",
+			opts: DDLOptions{
+				OrderBy: []string{"id"},
+				Cluster: &ClusterOptions{
+					Name:          "it's",
+					ZooKeeperPath: `/ch/{shard}/users`,
+					ReplicaName:   "{replica}-a",
+					ShardingKey:   "id % 4",
+				},
+			},
+			want: []string{
+				`CREATE TABLE IF NOT EXISTS "users_local" ON CLUSTER "it's" (id Int32, email String) ENGINE = ReplicatedMergeTree('/ch/{shard}/users', '{replica}-a') ORDER BY ("id");`,
+				`CREATE TABLE IF NOT EXISTS "users" ON CLUSTER "it's" AS "users_local" ENGINE = Distributed('it\'s', currentDatabase(), 'users_local', id % 4);`,
+			},
+		},
+		{
+			name: "type overrides",
+			opts: DDLOptions{Types: map[string]string{"email": "LowCardinality(String)"}, Cluster: &ClusterOptions{Name: "main"}},
+			want: []string{
+				`CREATE TABLE IF NOT EXISTS "users_local" ON CLUSTER "main" (id Int32, email LowCardinality(String)) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/{database}/{table}', '{replica}') ORDER BY tuple();`,
+				`CREATE TABLE IF NOT EXISTS "users" ON CLUSTER "main" AS "users_local" ENGINE = Distributed('main', currentDatabase(), 'users_local', rand());`,
+			},
+		},
+		{
+			name:    "no cluster",
+			opts:    DDLOptions{},
+			wantErr: "no cluster set for users",
+		},
+		{
+			name:    "unnamed cluster",
+			opts:    DDLOptions{Cluster: &ClusterOptions{}},
+			wantErr: "no cluster set for users",
+		},
+		{
+			name:    "override for an unknown column",
+			opts:    DDLOptions{Types: map[string]string{"phone": "String"}, Cluster: &ClusterOptions{Name: "main"}},
+			wantErr: "type override for unknown column phone",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := BuildClusterDDL("users", cols, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("BuildClusterDDL error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("BuildClusterDDL: %v", err)
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("BuildClusterDDL =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package etl
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type drainTimeoutKey struct{}
+
+type InterruptedError struct {
+	Inserted int
+	Err      error
+}
+
+func (e *InterruptedError) Error() string {
+	return fmt.Sprintf("interrupted after inserting %d rows: %v", e.Inserted, e.Err)
+}
+
+func (e *InterruptedError) Unwrap() error {
+	return e.Err
+}
+
+func WithDrainTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, drainTimeoutKey{}, timeout)
+}
+
+func drainContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout, _ := ctx.Value(drainTimeoutKey{}).(time.Duration)
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	drainCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(timeout, cancel)
+	})
+
+	return drainCtx, func() {
+		stop()
+		cancel()
+	}
+}
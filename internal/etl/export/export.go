@@ -8,43 +8,42 @@ import (
 	"pgtoch/internal/db"
 	"pgtoch/internal/etl"
 	"pgtoch/internal/log"
+	"pgtoch/internal/metrics"
 
 	"go.uber.org/zap"
 )
 
-func ExportTabletoCSV(chURL, table, outPath string) error {
-	conn, err := db.ConnectClickhouse(chURL)
+func ExportTabletoCSV(ctx context.Context, chURL string, opts db.ClickHouseOptions, table, outPath string) (int, error) {
+	conn, err := db.ConnectClickhouse(ctx, chURL, opts)
 	if err != nil {
-		return fmt.Errorf("failed to connect to clickhouse: %w", err)
+		return 0, fmt.Errorf("failed to connect to clickhouse: %w", err)
 	}
 	defer conn.Close()
 
-	exists, err := TableExists(conn, table)
+	exists, err := TableExists(ctx, conn, table)
 	if err != nil {
-		return fmt.Errorf("failed to check if table exists: %w", err)
+		return 0, fmt.Errorf("failed to check if table exists: %w", err)
 	}
 	if !exists {
-		return fmt.Errorf("table %s does not exist", table)
+		return 0, fmt.Errorf("table %s does not exist", table)
 	}
-	ctx := context.Background()
-
 	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT * FROM %s", etl.QuoteIdentifier(table)))
 	if err != nil {
-		return fmt.Errorf("query failed: %w", err)
+		return 0, fmt.Errorf("query failed: %w", err)
 	}
 	defer rows.Close()
 
 	cols, err := rows.Columns()
 	if err != nil {
-		return fmt.Errorf("failed to get columns: %w", err)
+		return 0, fmt.Errorf("failed to get columns: %w", err)
 	}
 	if len(cols) == 0 {
-		return fmt.Errorf("no columns found in table %s", table)
+		return 0, fmt.Errorf("no columns found in table %s", table)
 	}
 
 	file, err := os.Create(outPath)
 	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
+		return 0, fmt.Errorf("failed to create file: %w", err)
 	}
 	defer file.Close()
 
@@ -52,11 +51,21 @@ func ExportTabletoCSV(chURL, table, outPath string) error {
 	defer writer.Flush()
 
 	if err := writer.Write(cols); err != nil {
-		return fmt.Errorf("failed to write header: %w", err)
+		return 0, fmt.Errorf("failed to write header: %w", err)
 	}
 	count := 0
+	exported := metrics.RowsExported.WithLabelValues(table)
 
 	for rows.Next() {
+		if err := ctx.Err(); err != nil {
+			log.Logger.Warn("Export interrupted",
+				zap.String("table", table),
+				zap.Int("rows_count", count),
+				zap.String("outPath", outPath),
+			)
+			return count, err
+		}
+
 		columns := make([]any, len(cols))
 		columnPtrs := make([]any, len(cols))
 
@@ -64,7 +73,7 @@ func ExportTabletoCSV(chURL, table, outPath string) error {
 			columnPtrs[i] = &columns[i]
 		}
 		if err := rows.Scan(columnPtrs...); err != nil {
-			return fmt.Errorf("failed to scan row: %w", err)
+			return count, fmt.Errorf("failed to scan row: %w", err)
 		}
 		record := make([]string, len(cols))
 		for i, col := range columns {
@@ -75,9 +84,10 @@ func ExportTabletoCSV(chURL, table, outPath string) error {
 			}
 		}
 		if err := writer.Write(record); err != nil {
-			return fmt.Errorf("could not write to csv %w", err)
+			return count, fmt.Errorf("could not write to csv %w", err)
 		}
 		count++
+		exported.Inc()
 		if count%500 == 0 {
 			log.Logger.Info("Exported rows ..\n",
 				zap.Int("rows_count", count),
@@ -90,5 +100,5 @@ func ExportTabletoCSV(chURL, table, outPath string) error {
 		zap.Int("rows_count", count),
 		zap.String("outPath", outPath),
 	)
-	return nil
+	return count, nil
 }
@@ -3,8 +3,13 @@ package etl
 import (
 	"context"
 	"fmt"
+	"pgtoch/internal/events"
+	"pgtoch/internal/metrics"
+	"pgtoch/internal/tracing"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Column struct {
@@ -17,20 +22,41 @@ type TableData struct {
 	Rows    [][]any
 }
 
-func getColumns(ctx context.Context, conn *pgx.Conn, table string) ([]Column, error) {
+type Querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// MetadataSource is a Querier that reads catalog information from another
+// server, such as the primary when rows are read from a replica that may lag
+// behind schema changes.
+type MetadataSource interface {
+	Querier
+	Metadata() Querier
+}
+
+func metadata(conn Querier) Querier {
+	if m, ok := conn.(MetadataSource); ok {
+		return m.Metadata()
+	}
+	return conn
+}
+
+func getColumns(ctx context.Context, conn Querier, table string) (cols []Column, err error) {
+	ctx, span := tracing.Start(ctx, "discover schema", tracing.Table(table))
+	defer func() { tracing.End(span, err) }()
+
 	colQuery := `
 	SELECT column_name, data_type
 	FROM information_schema.columns
 	WHERE table_name = $1
 	ORDER BY ordinal_position
 	`
-	rows, err := conn.Query(ctx, colQuery, table)
+	rows, err := metadata(conn).Query(ctx, colQuery, table)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to query columns: %w", err)
 	}
 	defer rows.Close()
-	var cols []Column
 	for rows.Next() {
 		var col Column
 		if err := rows.Scan(&col.Name, &col.Type); err != nil {
@@ -42,21 +68,37 @@ func getColumns(ctx context.Context, conn *pgx.Conn, table string) ([]Column, er
 
 }
 
-func ExtractTableData(ctx context.Context, conn *pgx.Conn, table string, limit *int) (*TableData, error) {
+func TableColumns(ctx context.Context, conn Querier, table string) ([]Column, error) {
+	cols, err := getColumns(ctx, conn, table)
+	if err != nil {
+		return nil, err
+	}
+	if len(cols) == 0 {
+		return nil, fmt.Errorf("table %s not found or has no columns", table)
+	}
+	return cols, nil
+}
+
+func ExtractTableData(ctx context.Context, conn Querier, table string, limit *int, proj Projection) (td *TableData, err error) {
+	ctx, span := tracing.Start(ctx, "extract table", tracing.Table(table))
+	defer func() { endExtractSpan(span, td, err) }()
 
 	cols, err := getColumns(ctx, conn, table)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get columns: %w", err)
 	}
+	if cols, err = proj.Apply(table, cols); err != nil {
+		return nil, err
+	}
 
 	var query string
 	var rows pgx.Rows
 
 	if limit != nil && *limit > 0 {
-		query = "SELECT * FROM " + pgx.Identifier{table}.Sanitize() + "LIMIT $1"
+		query = "SELECT " + selectList(cols) + " FROM " + pgx.Identifier{table}.Sanitize() + " LIMIT $1"
 		rows, err = conn.Query(ctx, query, *limit)
 	} else {
-		query = "SELECT * FROM " + pgx.Identifier{table}.Sanitize()
+		query = "SELECT " + selectList(cols) + " FROM " + pgx.Identifier{table}.Sanitize()
 		rows, err = conn.Query(ctx, query)
 	}
 
@@ -65,66 +107,72 @@ func ExtractTableData(ctx context.Context, conn *pgx.Conn, table string, limit *
 	}
 	defer rows.Close()
 
-	var results [][]any
-
-	for rows.Next() {
-		values, err := rows.Values()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get row values: %w", err)
-		}
-		for i, val := range values {
-			var uuidBytes []byte
-			switch v := val.(type) {
-			case [16]byte:
-				uuidBytes = v[:]
-			case []byte:
-				uuidBytes = v
-			}
-
-			if uuidBytes != nil && (cols[i].Type == "uuid" || cols[i].Type == "bytea") {
-				if len(uuidBytes) == 16 {
-					values[i] = fmt.Sprintf("%x-%x-%x-%x-%x", uuidBytes[0:4], uuidBytes[4:6], uuidBytes[6:8], uuidBytes[8:10], uuidBytes[10:16])
-				}
-			}
-		}
-		results = append(results, values)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("failed to get rows: %w", err)
+	results, err := scanRows(rows, cols)
+	if err != nil {
+		return nil, err
 	}
 
+	metrics.RowsExtracted.WithLabelValues(events.Pipeline(ctx, table)).Add(float64(len(results)))
+	events.Publish(ctx, table, events.Event{Kind: events.Extracted, Rows: len(results)})
+
 	return &TableData{
 		Columns: cols,
 		Rows:    results,
 	}, nil
 }
 
-func ExtractTableDataSince(ctx context.Context, conn *pgx.Conn, table, deltaCol, lastSeen string, limit *int) (*TableData, error) {
+func ExtractTableDataSince(ctx context.Context, conn Querier, table, deltaCol, lastSeen string, limit *int, proj Projection) (td *TableData, err error) {
+	ctx, span := tracing.Start(ctx, "extract changes", tracing.Table(table))
+	defer func() { endExtractSpan(span, td, err) }()
 
 	cols, err := getColumns(ctx, conn, table)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get columns: %w", err)
 	}
+	if cols, err = proj.Apply(table, cols); err != nil {
+		return nil, err
+	}
+	if !hasColumn(cols, deltaCol) {
+		return nil, fmt.Errorf("delta column %s is not among the selected columns of %s", deltaCol, table)
+	}
+
+	quotedDelta := pgx.Identifier{deltaCol}.Sanitize()
+
+	var args []any
+	query := "SELECT " + selectList(cols) + " FROM " + pgx.Identifier{table}.Sanitize()
+	if lastSeen != "" {
+		args = append(args, lastSeen)
+		query += fmt.Sprintf(" WHERE %s > $%d", quotedDelta, len(args))
+	}
+	query += fmt.Sprintf(" ORDER BY %s ASC", quotedDelta)
 
-	query := fmt.Sprintf(
-		"SELECT * FROM %s WHERE %s > $1 ORDER BY %s ASC",
-		pgx.Identifier{table}.Sanitize(),
-		deltaCol,
-		deltaCol,
-	)
-	var rows pgx.Rows
 	if limit != nil && *limit > 0 {
-		query += fmt.Sprintf("LIMIT $1")
-		rows, err = conn.Query(ctx, query, lastSeen, *limit)
-	} else {
-		rows, err = conn.Query(ctx, query, lastSeen)
+		args = append(args, *limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
 	}
 
+	rows, err := conn.Query(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query table: %w", err)
 	}
 	defer rows.Close()
 
+	results, err := scanRows(rows, cols)
+	if err != nil {
+		return nil, fmt.Errorf("error iterating delta rows: %w", err)
+	}
+
+	metrics.RowsExtracted.WithLabelValues(events.Pipeline(ctx, table)).Add(float64(len(results)))
+	events.Publish(ctx, table, events.Event{Kind: events.Extracted, Rows: len(results)})
+
+	return &TableData{
+		Columns: cols,
+		Rows:    results,
+	}, nil
+
+}
+
+func scanRows(rows pgx.Rows, cols []Column) ([][]any, error) {
 	var results [][]any
 
 	for rows.Next() {
@@ -150,14 +198,53 @@ func ExtractTableDataSince(ctx context.Context, conn *pgx.Conn, table, deltaCol,
 		results = append(results, values)
 	}
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating delta rows: %w", err)
+		return nil, fmt.Errorf("failed to get rows: %w", err)
 	}
+	return results, nil
+}
 
-	return &TableData{
-		Columns: cols,
-		Rows:    results,
-	}, nil
+func endExtractSpan(span trace.Span, td *TableData, err error) {
+	if td != nil {
+		span.SetAttributes(tracing.Rows(len(td.Rows)), tracing.Bytes(RowBytes(td.Rows)))
+	}
+	tracing.End(span, err)
+}
 
+// RowBytes estimates the size of the values in rows. It is only used for
+// reporting, so values of other types count as their formatted length.
+func RowBytes(rows [][]any) int64 {
+	var n int64
+	for _, row := range rows {
+		for _, v := range row {
+			switch v := v.(type) {
+			case nil:
+			case string:
+				n += int64(len(v))
+			case []byte:
+				n += int64(len(v))
+			case bool, int8, uint8:
+				n++
+			case int16, uint16:
+				n += 2
+			case int32, uint32, float32:
+				n += 4
+			case int, int64, uint64, float64, time.Time:
+				n += 8
+			default:
+				n += int64(len(fmt.Sprint(v)))
+			}
+		}
+	}
+	return n
+}
+
+func hasColumn(cols []Column, name string) bool {
+	for _, col := range cols {
+		if col.Name == name {
+			return true
+		}
+	}
+	return false
 }
 
 func GetColumnNames(cols []Column) []string {
@@ -167,3 +254,62 @@ func GetColumnNames(cols []Column) []string {
 	}
 	return names
 }
+
+func LastDeltaValue(td *TableData, deltaCol string) (string, error) {
+	if len(td.Rows) == 0 {
+		return "", nil
+	}
+	return DeltaValueAt(td, deltaCol, len(td.Rows)-1)
+}
+
+func DeltaValueAt(td *TableData, deltaCol string, row int) (string, error) {
+	for i, col := range td.Columns {
+		if col.Name == deltaCol {
+			return FormatDeltaValue(td.Rows[row][i]), nil
+		}
+	}
+
+	return "", fmt.Errorf("delta column %s not found in table", deltaCol)
+}
+
+func FormatDeltaValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case int, int32, int64, int8, int16:
+		return fmt.Sprintf("%d", v)
+	case float64, float32:
+		return fmt.Sprintf("%f", v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func PrimaryKey(ctx context.Context, conn Querier, table string) (keys []string, err error) {
+	ctx, span := tracing.Start(ctx, "discover primary key", tracing.Table(table))
+	defer func() { tracing.End(span, err) }()
+
+	query := `
+	SELECT a.attname
+	FROM pg_index i
+	JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+	WHERE i.indrelid = $1::regclass AND i.indisprimary
+	ORDER BY array_position(i.indkey::int2[], a.attnum)
+	`
+	rows, err := metadata(conn).Query(ctx, query, pgx.Identifier{table}.Sanitize())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query primary key: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("failed to scan primary key column: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
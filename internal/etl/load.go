@@ -2,22 +2,45 @@ package etl
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
-	"pgtoch/internal/db"
+	"pgtoch/internal/events"
 	"pgtoch/internal/log"
+	"pgtoch/internal/metrics"
+	"pgtoch/internal/tracing"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 )
 
-func CreateTable(chURL, ddl string) error {
-	conn, err := db.ConnectClickhouse(chURL)
-	if err != nil {
-		return fmt.Errorf("failed to connect to clickhouse: %w", err)
-	}
-	defer conn.Close()
+type RejectedRow struct {
+	Row []any
+	Err error
+}
+
+type DeadLetterSink interface {
+	Write(ctx context.Context, table string, columns []string, rejected []RejectedRow) error
+}
+
+type LoadOptions struct {
+	BatchSize  int
+	Retry      RetryConfig
+	DeadLetter DeadLetterSink
+	MaxErrors  int
+}
 
-	_, err = conn.ExecContext(context.Background(), ddl)
+type LoadStats struct {
+	Inserted int
+	Rejected int
+	Dropped  int
+}
+
+var ErrTooManyRejected = errors.New("too many rejected rows")
+
+func CreateTable(ctx context.Context, conn *sql.DB, ddl string) error {
+	_, err := conn.ExecContext(tracing.ClickHouseContext(ctx), ddl)
 	if err != nil {
 		return fmt.Errorf("failed to create table: %w", err)
 	}
@@ -25,22 +48,27 @@ func CreateTable(chURL, ddl string) error {
 	return nil
 }
 
-func InsertRows(chURL, table string, columns []string, rows [][]any, batchSize int) error {
+func InsertRows(ctx context.Context, conn *sql.DB, table string, columns []string, rows [][]any, opts LoadOptions) (LoadStats, error) {
+	var stats LoadStats
+
 	if !IsValidIdentifier(table) {
-		return fmt.Errorf("invalid table name: %s", table)
+		return stats, fmt.Errorf("invalid table name: %s", table)
 	}
 
 	for _, col := range columns {
 		if !IsValidIdentifier(col) {
-			return fmt.Errorf("invalid column name: %s", col)
+			return stats, fmt.Errorf("invalid column name: %s", col)
 		}
 	}
 
-	conn, err := db.ConnectClickhouse(chURL)
-	if err != nil {
-		return fmt.Errorf("failed to connect to clickhouse: %w", err)
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = len(rows)
+	}
+
+	if opts.Retry.MaxAttempts == 0 {
+		opts.Retry = DefaultRetryConfig()
 	}
-	defer conn.Close()
 
 	quotedColumns := make([]string, len(columns))
 	for i, col := range columns {
@@ -53,39 +81,159 @@ func InsertRows(chURL, table string, columns []string, rows [][]any, batchSize i
 
 	insertPrefix := fmt.Sprintf("INSERT INTO %s %s VALUES", quotedTable, colNames)
 
-	ctx := context.Background()
+	// metrics count towards the source table, not a staging or local one
+	pipeline := events.Pipeline(ctx, table)
 
 	for i := 0; i < len(rows); i += batchSize {
+		if err := ctx.Err(); err != nil {
+			return stats, &InterruptedError{Inserted: i, Err: err}
+		}
+
 		end := min(i+batchSize, len(rows))
 		batch := rows[i:end]
 
-		query := insertPrefix + buildValuesPlaceholders(len(batch), len(columns))
-		args := flatten(batch)
-
-		err := Retry(ctx, RetryConfig{
-			MaxAttempts: 4,
-			BaseDelay:   250 * time.Millisecond,
-			MaxDelay:    10 * time.Second,
-			Jitter:      true,
-		}, func() error {
-			_, err := conn.ExecContext(ctx, query, args...)
-			if err != nil {
-				return fmt.Errorf("failed to insert batch: %w", err)
+		batchCtx, cancel := drainContext(ctx)
+		batchCtx, span := tracing.Start(batchCtx, "insert batch",
+			tracing.Table(table), tracing.Rows(len(batch)), tracing.Bytes(RowBytes(batch)))
+		ins := &batchInserter{
+			conn:          conn,
+			table:         table,
+			insertPrefix:  insertPrefix,
+			colCount:      len(columns),
+			opts:          opts,
+			rejectedSoFar: stats.Rejected,
+		}
+		start := time.Now()
+		err := ins.insert(batchCtx, batch)
+		metrics.BatchInsertDuration.WithLabelValues(pipeline).Observe(time.Since(start).Seconds())
+		span.SetAttributes(attribute.Int("pgtoch.rejected", len(ins.rejected)))
+		tracing.End(span, err)
+		cancel()
+
+		stats.Inserted += ins.inserted
+		metrics.RowsInserted.WithLabelValues(pipeline).Add(float64(ins.inserted))
+		events.Publish(ctx, table, events.Event{Kind: events.Inserted, Rows: ins.inserted})
+
+		rejected := ins.rejected
+		if len(rejected) > 0 {
+			stats.Rejected += len(rejected)
+			metrics.RowsDeadLettered.WithLabelValues(pipeline).Add(float64(len(rejected)))
+			log.Logger.Warn("Rejected rows in batch",
+				zap.String("table", table),
+				zap.Int("rejected", len(rejected)),
+				zap.Int("rejected_total", stats.Rejected),
+				zap.Error(rejected[0].Err),
+			)
+
+			// rows rejected before an abort are kept too
+			if err := opts.DeadLetter.Write(ctx, table, columns, rejected); err != nil {
+				return stats, fmt.Errorf("failed to write rejected rows to dead-letter sink: %w", err)
 			}
-			return nil
-		})
+		}
+		if err != nil && ctx.Err() != nil {
+			return stats, &InterruptedError{Inserted: i, Err: err}
+		}
+		if errors.Is(err, ErrTooManyRejected) {
+			return stats, err
+		}
 		if err != nil {
-			return fmt.Errorf("failed to insert rows into %s: %w", table, err)
+			return stats, fmt.Errorf("failed to insert rows into %s: %w", table, err)
 		}
 
 		log.Logger.Info("Inserted Into Clickhouse",
-			zap.Int("row_count", end-i),
+			zap.Int("row_count", ins.inserted),
 			zap.String("table", table),
 			zap.Int("batch_size", batchSize),
 			zap.Int("total_rows", len(rows)),
 		)
 
 	}
-	return nil
+	return stats, nil
+
+}
+
+// batchInserter loads one batch. When a batch fails with an error a retry
+// cannot fix, it bisects the batch to find the rejected rows, stopping as soon
+// as max_errors is exceeded or the failure turns out not to be row-specific.
+type batchInserter struct {
+	conn          *sql.DB
+	table         string
+	insertPrefix  string
+	colCount      int
+	opts          LoadOptions
+	rejectedSoFar int // by earlier batches
+
+	inserted int
+	rejected []RejectedRow
+}
 
+func (b *batchInserter) insert(ctx context.Context, batch [][]any) error {
+	rowErr, err := b.try(ctx, batch)
+	if err != nil || rowErr == nil {
+		return err
+	}
+	return b.bisect(ctx, batch, rowErr)
+}
+
+// try inserts batch. A failure bisecting may narrow down is returned as
+// rowErr; any other failure as err.
+func (b *batchInserter) try(ctx context.Context, batch [][]any) (rowErr, err error) {
+	query := b.insertPrefix + buildValuesPlaceholders(len(batch), b.colCount)
+	args := flatten(batch)
+
+	err = Retry(ctx, b.table, b.opts.Retry, func() error {
+		_, err := b.conn.ExecContext(tracing.ClickHouseContext(ctx), query, args...)
+		if err != nil {
+			return fmt.Errorf("failed to insert batch: %w", err)
+		}
+		return nil
+	})
+	if err == nil {
+		b.inserted += len(batch)
+		return nil, nil
+	}
+
+	var retryErr *RetryError
+	if b.opts.DeadLetter == nil || !errors.As(err, &retryErr) || retryErr.Retriable {
+		return nil, err
+	}
+	return retryErr.Err, nil
+}
+
+// bisect splits batch, which failed with rowErr, until the failing rows are
+// found.
+func (b *batchInserter) bisect(ctx context.Context, batch [][]any, rowErr error) error {
+	if len(batch) == 1 {
+		b.rejected = append(b.rejected, RejectedRow{Row: batch[0], Err: rowErr})
+		if total := b.rejectedSoFar + len(b.rejected); b.opts.MaxErrors >= 0 && total > b.opts.MaxErrors {
+			return fmt.Errorf("%w: %d rows rejected for %s (max_errors %d)", ErrTooManyRejected, total, b.table, b.opts.MaxErrors)
+		}
+		return nil
+	}
+
+	mid := len(batch) / 2
+	left, right := batch[:mid], batch[mid:]
+	leftErr, err := b.try(ctx, left)
+	if err != nil {
+		return err
+	}
+	rightErr, err := b.try(ctx, right)
+	if err != nil {
+		return err
+	}
+	// a missing column or permission fails every row alike, so dead-lettering
+	// them one by one would only hide it
+	if len(left) > 1 && leftErr != nil && rightErr != nil && leftErr.Error() == rightErr.Error() {
+		return fmt.Errorf("every part of the batch fails with the same error, not a row error: %w", leftErr)
+	}
+
+	if leftErr != nil {
+		if err := b.bisect(ctx, left, leftErr); err != nil {
+			return err
+		}
+	}
+	if rightErr != nil {
+		return b.bisect(ctx, right, rightErr)
+	}
+	return nil
 }
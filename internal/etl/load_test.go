@@ -0,0 +1,190 @@
+package etl
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"os"
+	"pgtoch/internal/events"
+	"pgtoch/internal/log"
+	"pgtoch/internal/metrics"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	log.Logger = zap.NewNop()
+	log.InitStyledLogger()
+	os.Exit(m.Run())
+}
+
+// fakeClickHouse fails inserts of rows whose first value is "bad", or every
+// insert when tableErr is set, and counts the statements it receives.
+type fakeClickHouse struct {
+	mu       sync.Mutex
+	tableErr error
+	execs    int
+}
+
+func (f *fakeClickHouse) Open(string) (driver.Conn, error) { return fakeConn{f}, nil }
+
+type fakeConn struct{ f *fakeClickHouse }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.f}, nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+type fakeStmt struct{ f *fakeClickHouse }
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+func (s fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.f.mu.Lock()
+	defer s.f.mu.Unlock()
+	s.f.execs++
+	if s.f.tableErr != nil {
+		return nil, s.f.tableErr
+	}
+	for i := 0; i < len(args); i += 2 {
+		if args[i] == "bad" {
+			return nil, fmt.Errorf("Code: 27. DB::Exception: Cannot parse input: bad value in row with id %v", args[i+1])
+		}
+	}
+	return driver.RowsAffected(len(args) / 2), nil
+}
+
+type memorySink struct{ rows []RejectedRow }
+
+func (m *memorySink) Write(_ context.Context, _ string, _ []string, rejected []RejectedRow) error {
+	m.rows = append(m.rows, rejected...)
+	return nil
+}
+
+var fakeDrivers sync.Map
+
+func openFake(t *testing.T, f *fakeClickHouse) *sql.DB {
+	name := "fake-" + t.Name()
+	if _, loaded := fakeDrivers.LoadOrStore(name, true); !loaded {
+		sql.Register(name, f)
+	}
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestInsertRowsRejects(t *testing.T) {
+	rows := func(bad ...int) [][]any {
+		out := make([][]any, 16)
+		for i := range out {
+			out[i] = []any{"ok", i}
+		}
+		for _, i := range bad {
+			out[i][0] = "bad"
+		}
+		return out
+	}
+
+	tests := []struct {
+		name         string
+		rows         [][]any
+		tableErr     error
+		maxErrors    int
+		wantInserted int
+		wantRejected int
+		wantErr      error
+		maxExecs     int
+	}{
+		{
+			name:         "bad rows are dead-lettered",
+			rows:         rows(3, 12),
+			maxErrors:    -1,
+			wantInserted: 14,
+			wantRejected: 2,
+		},
+		{
+			name:         "max errors stops the bisection",
+			rows:         rows(1, 2, 3, 9, 10, 11),
+			maxErrors:    1,
+			wantRejected: 2,
+			wantErr:      ErrTooManyRejected,
+			// the batch, then both halves of each split down to row 2;
+			// rows 9 to 11 are never tried
+			maxExecs: 11,
+		},
+		{
+			name:      "a table error is not bisected",
+			rows:      rows(),
+			tableErr:  errors.New("Code: 16. DB::Exception: No such column email"),
+			maxErrors: -1,
+			// the batch and its two halves
+			maxExecs: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeClickHouse{tableErr: tt.tableErr}
+			sink := &memorySink{}
+			stats, err := InsertRows(context.Background(), openFake(t, f), "users", []string{"status", "id"}, tt.rows, LoadOptions{
+				BatchSize:  16,
+				DeadLetter: sink,
+				MaxErrors:  tt.maxErrors,
+			})
+
+			switch {
+			case tt.tableErr != nil:
+				if err == nil {
+					t.Fatal("InsertRows succeeded, want the table error")
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("InsertRows error = %v, want %v", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Fatalf("InsertRows: %v", err)
+			}
+			if tt.wantErr == nil && tt.tableErr == nil && stats.Inserted != tt.wantInserted {
+				t.Errorf("inserted = %d, want %d", stats.Inserted, tt.wantInserted)
+			}
+			if len(sink.rows) != tt.wantRejected || stats.Rejected != tt.wantRejected {
+				t.Errorf("rejected = %d (sink %d), want %d", stats.Rejected, len(sink.rows), tt.wantRejected)
+			}
+			if tt.maxExecs > 0 && f.execs > tt.maxExecs {
+				t.Errorf("sent %d inserts, want at most %d", f.execs, tt.maxExecs)
+			}
+		})
+	}
+}
+
+func TestInsertRowsMetricsCountTowardsPipeline(t *testing.T) {
+	rows := [][]any{{"ok", 1}, {"ok", 2}, {"bad", 3}}
+	ctx := events.WithPipeline(context.Background(), "orders")
+	_, err := InsertRows(ctx, openFake(t, &fakeClickHouse{}), "orders"+stagingSuffix, []string{"status", "id"}, rows, LoadOptions{
+		DeadLetter: &memorySink{},
+		MaxErrors:  -1,
+	})
+	if err != nil {
+		t.Fatalf("InsertRows: %v", err)
+	}
+
+	if got := testutil.ToFloat64(metrics.RowsInserted.WithLabelValues("orders")); got != 2 {
+		t.Errorf("rows inserted for orders = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(metrics.RowsDeadLettered.WithLabelValues("orders")); got != 1 {
+		t.Errorf("rows dead-lettered for orders = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.RowsInserted.WithLabelValues("orders" + stagingSuffix)); got != 0 {
+		t.Errorf("rows inserted for the staging table = %v, want 0", got)
+	}
+}
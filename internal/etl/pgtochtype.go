@@ -26,3 +26,8 @@ var pgtochtype = map[string]string{
 	"inet":                        "String",
 	"USER-DEFINED":                "String",
 }
+
+func ClickHouseType(pgType string) (string, bool) {
+	chType, ok := pgtochtype[pgType]
+	return chType, ok
+}
@@ -0,0 +1,93 @@
+package etl
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Projection limits which source columns are read. Columns, when set, is the
+// exact list in the order given; Exclude removes columns from the result.
+type Projection struct {
+	Columns []string
+	Exclude []string
+}
+
+func (p Projection) Apply(table string, cols []Column) ([]Column, error) {
+	if len(p.Columns) == 0 && len(p.Exclude) == 0 {
+		return cols, nil
+	}
+
+	byName := make(map[string]Column, len(cols))
+	for _, col := range cols {
+		byName[col.Name] = col
+	}
+
+	selected := cols
+	if len(p.Columns) > 0 {
+		selected = make([]Column, 0, len(p.Columns))
+		for _, name := range p.Columns {
+			col, ok := byName[name]
+			if !ok {
+				return nil, fmt.Errorf("column %s not found in table %s", name, table)
+			}
+			selected = append(selected, col)
+		}
+	}
+
+	exclude := make(map[string]bool, len(p.Exclude))
+	for _, name := range p.Exclude {
+		if _, ok := byName[name]; !ok {
+			return nil, fmt.Errorf("excluded column %s not found in table %s", name, table)
+		}
+		exclude[name] = true
+	}
+
+	out := make([]Column, 0, len(selected))
+	for _, col := range selected {
+		if !exclude[col.Name] {
+			out = append(out, col)
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("column projection leaves no columns in table %s", table)
+	}
+	return out, nil
+}
+
+// Includes reports whether the projection keeps a column, assuming the
+// column exists in the table.
+func (p Projection) Includes(name string) bool {
+	for _, ex := range p.Exclude {
+		if ex == name {
+			return false
+		}
+	}
+	if len(p.Columns) == 0 {
+		return true
+	}
+	for _, col := range p.Columns {
+		if col == name {
+			return true
+		}
+	}
+	return false
+}
+
+func ProjectedColumns(ctx context.Context, conn Querier, table string, proj Projection) ([]Column, error) {
+	cols, err := TableColumns(ctx, conn, table)
+	if err != nil {
+		return nil, err
+	}
+	return proj.Apply(table, cols)
+}
+
+func selectList(cols []Column) string {
+	quoted := make([]string, len(cols))
+	for i, col := range cols {
+		quoted[i] = pgx.Identifier{col.Name}.Sanitize()
+	}
+	return strings.Join(quoted, ", ")
+}
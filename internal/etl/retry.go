@@ -2,11 +2,16 @@ package etl
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"math"
 	"math/rand"
+	"pgtoch/internal/events"
+	"pgtoch/internal/log"
+	"pgtoch/internal/metrics"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -15,9 +20,59 @@ type RetryConfig struct {
 	BaseDelay   time.Duration
 	MaxDelay    time.Duration
 	Jitter      bool
+	Classifier  func(error) bool
+	OnRetry     func(attempt int, err error)
 }
 
-func Retry(ctx context.Context, config RetryConfig, operation func() error) error {
+type RetryError struct {
+	Attempts  int
+	Retriable bool
+	Err       error
+}
+
+func (e *RetryError) Error() string {
+	if !e.Retriable {
+		return fmt.Sprintf("non-retriable error after %d attempt(s): %v", e.Attempts, e.Err)
+	}
+	return fmt.Sprintf("giving up after %d attempt(s): %v", e.Attempts, e.Err)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+func DefaultRetryConfig() RetryConfig {
+	return RetryConfig{
+		MaxAttempts: 4,
+		BaseDelay:   250 * time.Millisecond,
+		MaxDelay:    10 * time.Second,
+		Jitter:      true,
+	}
+}
+
+func (c RetryConfig) Backoff(attempt int) time.Duration {
+	backoff := min(c.BaseDelay*time.Duration(math.Pow(2, float64(attempt))), c.MaxDelay)
+	if c.Jitter {
+		jitterRange := float64(backoff) * 0.25
+		jitter := time.Duration(rand.Float64()*jitterRange*2 - jitterRange)
+		backoff += jitter
+
+		if backoff < 0 {
+			backoff = c.BaseDelay
+		}
+	}
+	return backoff
+}
+
+// Retry runs operation until it succeeds, fails with an error classify does
+// not retry, or MaxAttempts is reached. Retries are published as events of
+// table.
+func Retry(ctx context.Context, table string, config RetryConfig, operation func() error) error {
+	classify := config.Classifier
+	if classify == nil {
+		classify = IsRetriable
+	}
+
 	var attempt int
 	for {
 		err := operation()
@@ -25,29 +80,37 @@ func Retry(ctx context.Context, config RetryConfig, operation func() error) erro
 			return nil
 		}
 		attempt++
-		if attempt >= config.MaxAttempts {
-			return errors.New("max Attempts Reached")
+		if IsConnectionError(err) {
+			metrics.ConnectionErrors.Inc()
 		}
 
-		backoff := min(config.BaseDelay*time.Duration(math.Pow(2, float64(attempt))), config.MaxDelay)
-		if config.Jitter {
-			jitterRange := float64(backoff) * 0.25
-			jitter := time.Duration(rand.Float64()*jitterRange*2 - jitterRange)
-			backoff += jitter
+		if !classify(err) {
+			return &RetryError{Attempts: attempt, Retriable: false, Err: err}
+		}
+		if attempt >= config.MaxAttempts {
+			return &RetryError{Attempts: attempt, Retriable: true, Err: err}
+		}
 
-			if backoff < 0 {
-				backoff = config.BaseDelay
-			}
+		metrics.Retries.Inc()
+		if config.OnRetry != nil {
+			config.OnRetry(attempt, err)
 		}
+		backoff := config.Backoff(attempt)
+		events.Publish(ctx, table, events.Event{Kind: events.Retried, Duration: backoff, Err: err})
+		trace.SpanFromContext(ctx).AddEvent("retry", trace.WithAttributes(
+			attribute.Int("attempt", attempt),
+			attribute.String("error", err.Error()),
+			attribute.String("backoff", backoff.String()),
+		))
 
-		zap.L().Warn("Operation failed, retrying...",
+		log.Logger.Warn("Operation failed, retrying...",
 			zap.Int("attempt", attempt),
 			zap.Error(err),
 			zap.Duration("backoff", backoff),
 		)
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return &RetryError{Attempts: attempt, Retriable: true, Err: err}
 		case <-time.After(backoff):
 			continue
 		}
@@ -0,0 +1,127 @@
+package etl
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var retriableClickHouseCodes = map[int32]bool{
+	3:   true, // UNEXPECTED_END_OF_FILE
+	159: true, // TIMEOUT_EXCEEDED
+	160: true, // TOO_SLOW
+	164: true, // READONLY
+	202: true, // TOO_MANY_SIMULTANEOUS_QUERIES
+	203: true, // NO_FREE_CONNECTION
+	209: true, // SOCKET_TIMEOUT
+	210: true, // NETWORK_ERROR
+	241: true, // MEMORY_LIMIT_EXCEEDED
+	242: true, // TABLE_IS_READ_ONLY
+	252: true, // TOO_MANY_PARTS
+	285: true, // TOO_FEW_LIVE_REPLICAS
+	319: true, // UNKNOWN_STATUS_OF_INSERT
+	425: true, // SYSTEM_ERROR
+	999: true, // KEEPER_EXCEPTION
+}
+
+var retriablePostgresClasses = map[string]bool{
+	"08": true, // connection exception
+	"53": true, // insufficient resources
+	"57": true, // operator intervention (admin shutdown, statement timeout)
+	"58": true, // system error
+}
+
+var retriablePostgresCodes = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+}
+
+// clickHouseCodePattern matches the text of a ClickHouse exception, which the
+// HTTP protocol returns in the response body rather than as an Exception:
+// "Code: 241. DB::Exception: ..." or, before 21.x, "Code: 241,
+// e.displayText() = DB::Exception: ...".
+var clickHouseCodePattern = regexp.MustCompile(`\bCode: (\d+)(?:\.|, e\.displayText\(\) =) DB::Exception\b`)
+
+func IsRetriable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	if code, ok := ClickHouseErrorCode(err); ok {
+		return retriableClickHouseCodes[code]
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		if retriablePostgresCodes[pgErr.Code] {
+			return true
+		}
+		return len(pgErr.Code) >= 2 && retriablePostgresClasses[pgErr.Code[:2]]
+	}
+	if pgconn.SafeToRetry(err) || pgconn.Timeout(err) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// IsConnectionError reports whether err means the connection itself failed
+// rather than the statement.
+func IsConnectionError(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return strings.HasPrefix(pgErr.Code, "08")
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && !netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+func ClickHouseErrorCode(err error) (int32, bool) {
+	var exception *clickhouse.Exception
+	if errors.As(err, &exception) {
+		return exception.Code, true
+	}
+
+	if m := clickHouseCodePattern.FindStringSubmatch(err.Error()); m != nil {
+		code, convErr := strconv.ParseInt(m[1], 10, 32)
+		if convErr == nil {
+			return int32(code), true
+		}
+	}
+	return 0, false
+}
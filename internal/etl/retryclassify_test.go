@@ -0,0 +1,100 @@
+package etl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestClickHouseErrorCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		want   int32
+		wantOK bool
+	}{
+		{
+			name:   "native exception",
+			err:    &clickhouse.Exception{Code: 241, Message: "Memory limit exceeded"},
+			want:   241,
+			wantOK: true,
+		},
+		{
+			name:   "wrapped native exception",
+			err:    fmt.Errorf("insert batch: %w", &clickhouse.Exception{Code: 60}),
+			want:   60,
+			wantOK: true,
+		},
+		{
+			name:   "http exception text",
+			err:    errors.New("clickhouse [execute]:: 500 code: Code: 252. DB::Exception: Too many parts (300). (TOO_MANY_PARTS) (version 24.3.1.1)"),
+			want:   252,
+			wantOK: true,
+		},
+		{
+			name:   "http exception text before 21.x",
+			err:    errors.New("Code: 159, e.displayText() = DB::Exception: Timeout exceeded"),
+			want:   159,
+			wantOK: true,
+		},
+		{
+			name: "code in unrelated text",
+			err:  errors.New("row rejected: column status_code: 241 is not a valid enum value"),
+		},
+		{
+			name: "code without an exception",
+			err:  errors.New("quality rule failed: Code: 241 rows missing email"),
+		},
+		{
+			name: "lowercase code from another driver",
+			err:  errors.New("http status code: 503"),
+		},
+		{
+			name: "plain error",
+			err:  errors.New("connection refused"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ClickHouseErrorCode(tt.err)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("ClickHouseErrorCode() = %d, %v, want %d, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestIsRetriable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "canceled", err: context.Canceled, want: false},
+		{name: "deadline", err: fmt.Errorf("query: %w", context.DeadlineExceeded), want: true},
+		{name: "clickhouse memory limit", err: &clickhouse.Exception{Code: 241}, want: true},
+		{name: "clickhouse unknown table", err: &clickhouse.Exception{Code: 60}, want: false},
+		{name: "clickhouse syntax error over http", err: errors.New("Code: 62. DB::Exception: Syntax error"), want: false},
+		{name: "clickhouse timeout over http", err: errors.New("Code: 159. DB::Exception: Timeout exceeded"), want: true},
+		{name: "postgres serialization failure", err: &pgconn.PgError{Code: "40001"}, want: true},
+		{name: "postgres admin shutdown", err: &pgconn.PgError{Code: "57P01"}, want: true},
+		{name: "postgres undefined column", err: &pgconn.PgError{Code: "42703"}, want: false},
+		{name: "connection reset", err: fmt.Errorf("read: %w", syscall.ECONNRESET), want: true},
+		{name: "unexpected eof", err: io.ErrUnexpectedEOF, want: true},
+		{name: "code text in a permanent error", err: errors.New("invalid value for Code: 241"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetriable(tt.err); got != tt.want {
+				t.Errorf("IsRetriable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
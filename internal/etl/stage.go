@@ -0,0 +1,78 @@
+package etl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// Stage rewrites a batch after extraction and before it is loaded. Stages may
+// drop rows, so row positions in the output do not line up with the input.
+type Stage interface {
+	Apply(ctx context.Context, td *TableData) (*TableData, error)
+}
+
+func ApplyStages(ctx context.Context, td *TableData, stages ...Stage) (*TableData, error) {
+	var err error
+	for _, stage := range stages {
+		if td, err = stage.Apply(ctx, td); err != nil {
+			return nil, err
+		}
+	}
+	return td, nil
+}
+
+// LoadBatch runs td through the stages and inserts what is left. When rows
+// were dropped the Inserted count of an InterruptedError no longer maps to a
+// position in td, so it is reset and callers fall back to redoing the batch.
+func LoadBatch(ctx context.Context, conn *sql.DB, table string, td *TableData, stages []Stage, opts LoadOptions) (LoadStats, error) {
+	out, err := ApplyStages(ctx, td, stages...)
+	if err != nil {
+		return LoadStats{}, err
+	}
+
+	stats, err := InsertRows(ctx, conn, table, GetColumnNames(out.Columns), out.Rows, opts)
+	stats.Dropped = len(td.Rows) - len(out.Rows)
+
+	var interrupted *InterruptedError
+	if stats.Dropped != 0 && errors.As(err, &interrupted) {
+		interrupted.Inserted = 0
+	}
+	return stats, err
+}
+
+// SchemaStage is a Stage that changes the columns of a batch. Schema
+// describes the output columns so the target table matches them, and
+// TargetName maps a source column to its output name (false if dropped).
+type SchemaStage interface {
+	Stage
+	Schema(cols []Column) ([]Column, error)
+	TargetName(col string) (string, bool)
+}
+
+func StageSchema(cols []Column, stages ...Stage) ([]Column, error) {
+	var err error
+	for _, stage := range stages {
+		s, ok := stage.(SchemaStage)
+		if !ok {
+			continue
+		}
+		if cols, err = s.Schema(cols); err != nil {
+			return nil, err
+		}
+	}
+	return cols, nil
+}
+
+func StageColumnName(col string, stages ...Stage) (string, bool) {
+	for _, stage := range stages {
+		s, ok := stage.(SchemaStage)
+		if !ok {
+			continue
+		}
+		if col, ok = s.TargetName(col); !ok {
+			return "", false
+		}
+	}
+	return col, true
+}
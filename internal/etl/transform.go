@@ -1,14 +1,39 @@
 package etl
 
 import (
+	"context"
 	"fmt"
+	"pgtoch/internal/tracing"
+	"slices"
 	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
+type DDLOptions struct {
+	Engine  string
+	OrderBy []string
+	Version string
+	// Types overrides the mapped ClickHouse type of these columns.
+	Types map[string]string
+	// Cluster, when set, creates the table across a ClickHouse cluster.
+	Cluster *ClusterOptions
+}
+
 func MapColumnType(cols []Column) ([]string, error) {
+	return mapColumns(cols, nil)
+}
+
+func mapColumns(cols []Column, overrides map[string]string) ([]string, error) {
+	for name := range overrides {
+		if !slices.ContainsFunc(cols, func(c Column) bool { return c.Name == name }) {
+			return nil, fmt.Errorf("type override for unknown column %s", name)
+		}
+	}
+
 	var mapped []string
 	for _, col := range cols {
-		chType, ok := pgtochtype[col.Type]
+		chType, ok := ColumnType(col, overrides)
 		if !ok {
 			return nil, fmt.Errorf("unsupported column type: %s", col.Type)
 		}
@@ -17,14 +42,67 @@ func MapColumnType(cols []Column) ([]string, error) {
 	return mapped, nil
 }
 
+// ColumnType is the type col is created with in ClickHouse, or false when
+// its Postgres type has no mapping and no override.
+func ColumnType(col Column, overrides map[string]string) (string, bool) {
+	if chType, ok := overrides[col.Name]; ok {
+		return chType, true
+	}
+	return ClickHouseType(col.Type)
+}
+
 func BuildDDLQuery(table string, cols []Column) (string, error) {
-	mappedCols, err := MapColumnType(cols)
+	return BuildDDL(table, cols, DDLOptions{})
+}
+
+// buildDDL returns the statements that create the table, one for a single
+// node and two on a cluster.
+func buildDDL(ctx context.Context, table string, cols []Column, opts DDLOptions) (ddl []string, err error) {
+	_, span := tracing.Start(ctx, "build ddl", tracing.Table(table), attribute.Int("pgtoch.columns", len(cols)))
+	defer func() { tracing.End(span, err) }()
+
+	if opts.Cluster != nil {
+		return BuildClusterDDL(table, cols, opts)
+	}
+	stmt, err := BuildDDL(table, cols, opts)
+	if err != nil {
+		return nil, err
+	}
+	return []string{stmt}, nil
+}
+
+func BuildDDL(table string, cols []Column, opts DDLOptions) (string, error) {
+	mappedCols, err := mapColumns(cols, opts.Types)
 	if err != nil {
 		return "", err
 	}
-	ddl := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s) ENGINE = MergeTree() ORDER BY tuple();", QuoteIdentifier(table), strings.Join(mappedCols, ", "))
 	if len(mappedCols) == 0 {
 		return "", fmt.Errorf("no columns to create table")
 	}
+
+	engine := "MergeTree()"
+	if opts.Engine != "" {
+		engine = fmt.Sprintf("%s(%s)", opts.Engine, engineArgs(nil, opts.Version))
+	}
+
+	ddl := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s) ENGINE = %s ORDER BY %s;", QuoteIdentifier(table), strings.Join(mappedCols, ", "), engine, orderByClause(opts.OrderBy))
 	return ddl, nil
 }
+
+func engineArgs(args []string, version string) string {
+	if version != "" {
+		args = append(args, QuoteIdentifier(version))
+	}
+	return strings.Join(args, ", ")
+}
+
+func orderByClause(keys []string) string {
+	if len(keys) == 0 {
+		return "tuple()"
+	}
+	quoted := make([]string, len(keys))
+	for i, col := range keys {
+		quoted[i] = QuoteIdentifier(col)
+	}
+	return "(" + strings.Join(quoted, ", ") + ")"
+}
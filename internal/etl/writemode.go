@@ -0,0 +1,304 @@
+package etl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"pgtoch/internal/log"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+type WriteMode string
+
+const (
+	ModeAppend        WriteMode = "append"
+	ModeTruncate      WriteMode = "truncate"
+	ModeReplace       WriteMode = "replace"
+	ModeUpsert        WriteMode = "upsert"
+	ModeErrorIfExists WriteMode = "error-if-exists"
+)
+
+var WriteModes = []WriteMode{ModeAppend, ModeTruncate, ModeReplace, ModeUpsert, ModeErrorIfExists}
+
+var ErrTableNotEmpty = errors.New("target table already contains data")
+
+const stagingSuffix = "__pgtoch_tmp"
+
+func ParseWriteMode(s string) (WriteMode, error) {
+	if s == "" {
+		return ModeAppend, nil
+	}
+	for _, mode := range WriteModes {
+		if WriteMode(s) == mode {
+			return mode, nil
+		}
+	}
+
+	names := make([]string, len(WriteModes))
+	for i, mode := range WriteModes {
+		names[i] = string(mode)
+	}
+	return "", fmt.Errorf("unknown write mode %q (expected one of %s)", s, strings.Join(names, ", "))
+}
+
+func (m WriteMode) Describe() string {
+	switch m {
+	case ModeTruncate:
+		return "truncate the table, then load"
+	case ModeReplace:
+		return "load into a staging table and swap it in"
+	case ModeUpsert:
+		return "ReplacingMergeTree deduplicated on keys"
+	case ModeErrorIfExists:
+		return "refuse to write into a non-empty table"
+	default:
+		return "append to the table"
+	}
+}
+
+type Target struct {
+	Table     string
+	LoadTable string
+	Mode      WriteMode
+	DDL       string
+}
+
+func PrepareTarget(ctx context.Context, conn *sql.DB, table string, cols []Column, mode WriteMode, opts DDLOptions) (*Target, error) {
+	target := &Target{Table: table, LoadTable: opts.Cluster.LoadTable(table), Mode: mode}
+
+	if opts.Cluster != nil && mode == ModeReplace {
+		return nil, fmt.Errorf("write mode %s is not supported on a cluster; use truncate", mode)
+	}
+
+	if mode == ModeUpsert {
+		if len(opts.OrderBy) == 0 {
+			return nil, fmt.Errorf("upsert mode requires dedup keys for %s", table)
+		}
+		opts.Engine = "ReplacingMergeTree"
+
+		// CREATE TABLE IF NOT EXISTS keeps a table created for another mode,
+		// which would append duplicates instead of replacing rows
+		existing := table
+		if opts.Cluster != nil {
+			existing = LocalTableName(table)
+		}
+		if err := checkUpsertTable(ctx, conn, existing, opts.OrderBy); err != nil {
+			return nil, err
+		}
+	}
+
+	if mode == ModeReplace {
+		log.Logger.Info("Write mode replace: loading into staging table", zap.String("table", table))
+		staging, ddl, err := PrepareStagingTable(ctx, conn, table, cols, opts)
+		if err != nil {
+			return nil, err
+		}
+		target.LoadTable, target.DDL = staging, ddl
+		return target, nil
+	}
+
+	if mode == ModeErrorIfExists {
+		exists, err := TableExists(ctx, conn, table)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check if %s exists: %w", table, err)
+		}
+		if exists {
+			count, err := CountRows(ctx, conn, table)
+			if err != nil {
+				return nil, err
+			}
+			if count > 0 {
+				return nil, fmt.Errorf("%w: %s has %d rows", ErrTableNotEmpty, table, count)
+			}
+		}
+	}
+
+	ddl, err := buildDDL(ctx, table, cols, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build DDL query: %w", err)
+	}
+	for _, stmt := range ddl {
+		if err := CreateTable(ctx, conn, stmt); err != nil {
+			return nil, err
+		}
+	}
+	target.DDL = strings.Join(ddl, "\n")
+
+	switch mode {
+	case ModeTruncate:
+		log.Logger.Info("Write mode truncate: truncating table", zap.String("table", table))
+		// a Distributed table holds no data, its shards' local tables do
+		truncate := table
+		if opts.Cluster != nil {
+			truncate = LocalTableName(table)
+		}
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("TRUNCATE TABLE %s%s", QuoteIdentifier(truncate), opts.Cluster.onCluster())); err != nil {
+			return nil, fmt.Errorf("failed to truncate %s: %w", table, err)
+		}
+	case ModeUpsert:
+		log.Logger.Info("Write mode upsert: rows deduplicated on keys",
+			zap.String("table", table),
+			zap.Strings("keys", opts.OrderBy),
+			zap.String("version", opts.Version),
+		)
+	default:
+		log.Logger.Info("Write mode "+string(mode)+": appending rows", zap.String("table", table))
+	}
+
+	return target, nil
+}
+
+func ResumeTarget(ctx context.Context, conn *sql.DB, table string, mode WriteMode, cluster *ClusterOptions) (*Target, error) {
+	target := &Target{Table: table, LoadTable: cluster.LoadTable(table), Mode: mode}
+	if mode == ModeReplace {
+		target.LoadTable = StagingTableName(table)
+	}
+
+	exists, err := TableExists(ctx, conn, target.LoadTable)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if %s exists: %w", target.LoadTable, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("cannot resume: %s no longer exists in clickhouse", target.LoadTable)
+	}
+
+	log.Logger.Info("Resuming into existing table", zap.String("table", table), zap.String("load_table", target.LoadTable))
+	return target, nil
+}
+
+func (t *Target) Finalize(ctx context.Context, conn *sql.DB, inserted int) error {
+	if t.Mode != ModeReplace {
+		return nil
+	}
+	if err := VerifyRowCount(ctx, conn, t.LoadTable, inserted); err != nil {
+		return err
+	}
+	return SwapStagingTable(ctx, conn, t.Table)
+}
+
+func StagingTableName(table string) string {
+	return table + stagingSuffix
+}
+
+func TableExists(ctx context.Context, conn *sql.DB, table string) (bool, error) {
+	var exists uint8
+	err := conn.QueryRowContext(ctx, fmt.Sprintf("EXISTS TABLE %s", QuoteIdentifier(table))).Scan(&exists)
+	return exists == 1, err
+}
+
+// checkUpsertTable refuses an existing table that would not deduplicate on
+// keys: its engine must be a ReplacingMergeTree sorted by exactly keys.
+func checkUpsertTable(ctx context.Context, conn *sql.DB, table string, keys []string) error {
+	var engine, sortingKey string
+	err := conn.QueryRowContext(ctx,
+		"SELECT engine, sorting_key FROM system.tables WHERE database = currentDatabase() AND name = ?", table,
+	).Scan(&engine, &sortingKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to inspect %s: %w", table, err)
+	}
+
+	if !strings.HasSuffix(engine, "ReplacingMergeTree") {
+		return fmt.Errorf("upsert mode needs a ReplacingMergeTree table, but %s already exists with engine %s; drop it or load it with write mode replace", table, engine)
+	}
+	if existing := splitSortingKey(sortingKey); !slices.Equal(existing, keys) {
+		return fmt.Errorf("upsert keys (%s) do not match the sorting key (%s) of the existing table %s", strings.Join(keys, ", "), strings.Join(existing, ", "), table)
+	}
+	return nil
+}
+
+// splitSortingKey turns a sorting_key from system.tables into column names.
+func splitSortingKey(key string) []string {
+	var cols []string
+	for _, col := range strings.Split(key, ",") {
+		if col = strings.Trim(strings.TrimSpace(col), "`"); col != "" {
+			cols = append(cols, col)
+		}
+	}
+	return cols
+}
+
+func CountRows(ctx context.Context, conn *sql.DB, table string) (uint64, error) {
+	var count uint64
+	err := conn.QueryRowContext(ctx, fmt.Sprintf("SELECT count() FROM %s", QuoteIdentifier(table))).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count rows in %s: %w", table, err)
+	}
+	return count, nil
+}
+
+func PrepareStagingTable(ctx context.Context, conn *sql.DB, table string, cols []Column, opts DDLOptions) (string, string, error) {
+	staging := StagingTableName(table)
+
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", QuoteIdentifier(staging))); err != nil {
+		return "", "", fmt.Errorf("failed to drop stale staging table %s: %w", staging, err)
+	}
+
+	ddl, err := buildDDL(ctx, staging, cols, opts)
+	if err != nil {
+		return "", "", err
+	}
+	for _, stmt := range ddl {
+		if err := CreateTable(ctx, conn, stmt); err != nil {
+			return "", "", err
+		}
+	}
+
+	log.Logger.Info("Created staging table", zap.String("table", table), zap.String("staging", staging))
+	return staging, strings.Join(ddl, "\n"), nil
+}
+
+func VerifyRowCount(ctx context.Context, conn *sql.DB, table string, expected int) error {
+	count, err := CountRows(ctx, conn, table)
+	if err != nil {
+		return err
+	}
+	if count != uint64(expected) {
+		return fmt.Errorf("row count mismatch in %s: expected %d, found %d", table, expected, count)
+	}
+	return nil
+}
+
+func SwapStagingTable(ctx context.Context, conn *sql.DB, table string) error {
+	staging := StagingTableName(table)
+	quotedTable, quotedStaging := QuoteIdentifier(table), QuoteIdentifier(staging)
+
+	exists, err := TableExists(ctx, conn, table)
+	if err != nil {
+		return fmt.Errorf("failed to check if %s exists: %w", table, err)
+	}
+
+	if !exists {
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("RENAME TABLE %s TO %s", quotedStaging, quotedTable)); err != nil {
+			return fmt.Errorf("failed to rename %s to %s: %w", staging, table, err)
+		}
+		return nil
+	}
+
+	_, err = conn.ExecContext(ctx, fmt.Sprintf("EXCHANGE TABLES %s AND %s", quotedTable, quotedStaging))
+	if err != nil {
+		log.Logger.Warn("EXCHANGE TABLES not supported, falling back to RENAME",
+			zap.String("table", table),
+			zap.Error(err),
+		)
+		old := QuoteIdentifier(table + "__pgtoch_old")
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", old)); err != nil {
+			return fmt.Errorf("failed to drop leftover table: %w", err)
+		}
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("RENAME TABLE %s TO %s, %s TO %s", quotedTable, old, quotedStaging, quotedTable)); err != nil {
+			return fmt.Errorf("failed to swap %s into %s: %w", staging, table, err)
+		}
+		quotedStaging = old
+	}
+
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", quotedStaging)); err != nil {
+		log.Logger.Warn("Failed to drop previous table data after swap", zap.String("table", table), zap.Error(err))
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type Kind int
+
+const (
+	// Started opens a run. Total is the expected row count, 0 if unknown.
+	Started Kind = iota
+	// Extracted and Inserted carry the Rows read from Postgres and written to
+	// ClickHouse by one batch.
+	Extracted
+	Inserted
+	// Retried is an attempt that failed and is retried after Duration.
+	Retried
+	// PollCycle reports one cycle of a poller: Rows loaded, Watermark, Lag
+	// when the watermark is a timestamp, Duration and Err.
+	PollCycle
+)
+
+// Event is one step of a pipeline. Commands ignore them; the interactive
+// console draws progress from them.
+type Event struct {
+	Pipeline  string
+	Kind      Kind
+	Rows      int
+	Total     int
+	Watermark string
+	Lag       time.Duration
+	Duration  time.Duration
+	Err       error
+	Time      time.Time
+}
+
+type pipelineKey struct{}
+
+// WithPipeline names the pipeline events published under ctx belong to, so
+// rows loaded into a staging or local table count towards their table.
+func WithPipeline(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, pipelineKey{}, name)
+}
+
+// Pipeline returns the pipeline named in ctx, or table if there is none.
+func Pipeline(ctx context.Context, table string) string {
+	if name, ok := ctx.Value(pipelineKey{}).(string); ok {
+		return name
+	}
+	return table
+}
+
+var (
+	mu   sync.RWMutex
+	subs []chan Event
+)
+
+// Subscribe returns a channel receiving every event until cancel is called.
+// Events are dropped rather than block a pipeline on a slow subscriber.
+func Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, 4096)
+	mu.Lock()
+	subs = append(subs, ch)
+	mu.Unlock()
+
+	cancel := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for i, s := range subs {
+			if s == ch {
+				subs = append(subs[:i], subs[i+1:]...)
+				close(ch)
+				return
+			}
+		}
+	}
+	return ch, cancel
+}
+
+// Publish sends e to the subscribers. table names the pipeline unless ctx
+// carries one.
+func Publish(ctx context.Context, table string, e Event) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if len(subs) == 0 {
+		return
+	}
+
+	e.Pipeline = Pipeline(ctx, table)
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	for _, ch := range subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
@@ -1,14 +1,33 @@
 package log
 
-import "go.uber.org/zap"
+import (
+	"io"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
 
 var Logger *zap.Logger
 
 func InitLogger() {
 	var err error
-	Logger, err = zap.NewProduction()
+	Logger, err = zap.NewProduction(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return redactingCore{core}
+	}))
 	if err != nil {
 		panic("failed to initialize logger:" + err.Error())
 	}
 	InitStyledLogger()
 }
+
+// RedirectTo writes the JSON log to w instead of stderr, for as long as a
+// full-screen UI owns the terminal.
+func RedirectTo(w io.Writer) {
+	core := zapcore.NewCore(
+		zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
+		zapcore.AddSync(w),
+		zap.InfoLevel,
+	)
+	Logger = zap.New(redactingCore{core})
+	InitStyledLogger()
+}
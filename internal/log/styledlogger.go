@@ -2,6 +2,7 @@ package log
 
 import (
 	ui "pgtoch/internal/UI"
+	"pgtoch/internal/redact"
 
 	"go.uber.org/zap"
 )
@@ -18,27 +19,27 @@ func NewStyledLogger() *StyledLogger {
 
 func (s *StyledLogger) Info(msg string, fields ...zap.Field) {
 	s.logger.Info(msg, fields...)
-	ui.PrintInfo(msg)
+	ui.PrintInfo(redact.String(msg))
 }
 
 func (s *StyledLogger) Success(msg string, fields ...zap.Field) {
 	s.logger.Info("Sucess", fields...)
-	ui.PrintSuccess(msg)
+	ui.PrintSuccess(redact.String(msg))
 }
 
 func (s *StyledLogger) Error(msg string, fields ...zap.Field) {
 	s.logger.Error(msg, fields...)
-	ui.PrintError(msg)
+	ui.PrintError(redact.String(msg))
 }
 
 func (s *StyledLogger) Warn(msg string, fields ...zap.Field) {
 	s.logger.Warn(msg, fields...)
-	ui.PrintWarning(msg)
+	ui.PrintWarning(redact.String(msg))
 }
 
 func (s *StyledLogger) Fatal(msg string, fields ...zap.Field) {
 	s.logger.Fatal(msg, fields...)
-	ui.ExitWithError(msg)
+	ui.ExitWithError(redact.String(msg))
 }
 
 func (s *StyledLogger) Debug(msg string, fields ...zap.Field) {
@@ -47,7 +48,7 @@ func (s *StyledLogger) Debug(msg string, fields ...zap.Field) {
 
 func (s *StyledLogger) Highligh(msg string, fields ...zap.Field) {
 	s.logger.Info(msg, fields...)
-	ui.PrintHighlight(msg)
+	ui.PrintHighlight(redact.String(msg))
 }
 
 func (s *StyledLogger) With(fields ...zap.Field) *StyledLogger {
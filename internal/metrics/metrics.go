@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var Registry = prometheus.NewRegistry()
+
+var (
+	RowsExtracted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pgtoch_rows_extracted_total",
+		Help: "Rows read from the source, per table.",
+	}, []string{"table"})
+
+	RowsInserted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pgtoch_rows_inserted_total",
+		Help: "Rows inserted into ClickHouse, per table.",
+	}, []string{"table"})
+
+	RowsDeadLettered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pgtoch_rows_dead_lettered_total",
+		Help: "Rows rejected by ClickHouse and sent to the dead-letter sink, per table.",
+	}, []string{"table"})
+
+	RowsExported = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pgtoch_rows_exported_total",
+		Help: "Rows written by export, per table.",
+	}, []string{"table"})
+
+	BatchInsertDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pgtoch_batch_insert_duration_seconds",
+		Help:    "Time to insert one batch into ClickHouse, including retries.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 14),
+	}, []string{"table"})
+
+	Retries = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pgtoch_retries_total",
+		Help: "Operations retried after a transient failure.",
+	})
+
+	ConnectionErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pgtoch_connection_errors_total",
+		Help: "Failed connection attempts and dropped connections to Postgres or ClickHouse.",
+	})
+
+	PollLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgtoch_poll_lag_seconds",
+		Help: "Time between now and the last watermark, for timestamp delta columns.",
+	}, []string{"table"})
+
+	LastPollSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgtoch_last_successful_poll_timestamp_seconds",
+		Help: "Unix time of the last poll cycle that completed without error.",
+	}, []string{"table"})
+
+	PollCycleDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pgtoch_poll_cycle_duration_seconds",
+		Help:    "Time taken by one poll cycle, from extraction to load.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 16),
+	}, []string{"table"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		RowsExtracted,
+		RowsInserted,
+		RowsDeadLettered,
+		RowsExported,
+		BatchInsertDuration,
+		Retries,
+		ConnectionErrors,
+		PollLag,
+		LastPollSuccess,
+		PollCycleDuration,
+	)
+}
+
+// ObservePollWatermark updates the lag gauge when the watermark is a
+// timestamp. Numeric watermarks have no meaningful lag and are ignored.
+func ObservePollWatermark(table, watermark string) {
+	if lag, ok := WatermarkLag(watermark); ok {
+		PollLag.WithLabelValues(table).Set(lag.Seconds())
+	}
+}
+
+// WatermarkLag is the time since a timestamp watermark.
+func WatermarkLag(watermark string) (time.Duration, bool) {
+	t, err := time.Parse(time.RFC3339Nano, watermark)
+	if err != nil {
+		return 0, false
+	}
+	return time.Since(t), true
+}
+
+// Serve exposes /metrics on addr until ctx is cancelled.
+func Serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -2,30 +2,56 @@ package poller
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"pgtoch/internal/etl"
+	"pgtoch/internal/events"
 	"pgtoch/internal/log"
+	"pgtoch/internal/metrics"
+	"pgtoch/internal/tracing"
 	"time"
 
-	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 )
 
+const DefaultMaxFailures = 10
+
+var ErrTooManyFailures = errors.New("too many consecutive polling failures")
+
 type PollConfig struct {
-	Table     string
-	DeltaCol  string
-	Interval  time.Duration
-	Limit     *int
-	StartFrom string
-	OnData    func(data *etl.TableData) error
+	Table        string
+	DeltaCol     string
+	Interval     time.Duration
+	Limit        *int
+	Projection   etl.Projection
+	StartFrom    string
+	MaxFailures  int
+	Backoff      etl.RetryConfig
+	Retry        etl.RetryConfig
+	OnData       func(ctx context.Context, data *etl.TableData) error
+	OnCheckpoint func(lastSeen string) error
 }
 
 type Poller struct {
-	conn   *pgx.Conn
+	conn   etl.Querier
 	config PollConfig
 }
 
-func NewPoller(conn *pgx.Conn, config PollConfig) *Poller {
+func NewPoller(conn etl.Querier, config PollConfig) *Poller {
+	if config.MaxFailures == 0 {
+		config.MaxFailures = DefaultMaxFailures
+	}
+	if config.Retry.MaxAttempts == 0 {
+		config.Retry = etl.DefaultRetryConfig()
+	}
+	if config.Backoff.BaseDelay == 0 {
+		config.Backoff = etl.RetryConfig{
+			BaseDelay: time.Second,
+			MaxDelay:  time.Minute,
+			Jitter:    true,
+		}
+	}
 	return &Poller{
 		conn:   conn,
 		config: config,
@@ -35,6 +61,7 @@ func NewPoller(conn *pgx.Conn, config PollConfig) *Poller {
 func (p *Poller) Start(ctx context.Context) error {
 
 	lastSeen := p.config.StartFrom
+	failures := 0
 
 	ticker := time.NewTicker(p.config.Interval)
 
@@ -46,58 +73,133 @@ func (p *Poller) Start(ctx context.Context) error {
 			log.Logger.Info("Stopping ctx cancelled")
 			return ctx.Err()
 		case <-ticker.C:
-			log.Logger.Info("Polling for new data",
-				zap.String("table", p.config.Table),
-				zap.String("last_seen", lastSeen),
-			)
-			data, err := etl.ExtractTableDataSince(ctx, p.conn, p.config.Table, p.config.DeltaCol, lastSeen, p.config.Limit)
+			start := time.Now()
+			cycleCtx, span := tracing.Start(ctx, "poll cycle", tracing.Table(p.config.Table))
+			nextSeen, rows, err := p.poll(cycleCtx, lastSeen)
+			span.SetAttributes(attribute.String("pgtoch.watermark", nextSeen))
+			tracing.End(span, err)
+			metrics.PollCycleDuration.WithLabelValues(p.config.Table).Observe(time.Since(start).Seconds())
+			if nextSeen != lastSeen {
+				lastSeen = nextSeen
+				p.checkpoint(lastSeen)
+			}
+			metrics.ObservePollWatermark(p.config.Table, lastSeen)
+			lag, _ := metrics.WatermarkLag(lastSeen)
+			events.Publish(ctx, p.config.Table, events.Event{
+				Kind:      events.PollCycle,
+				Rows:      rows,
+				Watermark: lastSeen,
+				Lag:       lag,
+				Duration:  time.Since(start),
+				Err:       err,
+			})
+
 			if err != nil {
-				log.Logger.Error("Error extracting table data",
+				if ctx.Err() != nil {
+					log.Logger.Info("Polling interrupted",
+						zap.String("table", p.config.Table),
+						zap.String("last_seen", lastSeen),
+					)
+					return ctx.Err()
+				}
+
+				failures++
+				log.Logger.Error("Polling cycle failed",
 					zap.Error(err),
 					zap.String("table", p.config.Table),
+					zap.Int("consecutive_failures", failures),
 				)
-				continue
-			}
 
-			if len(data.Rows) == 0 {
-				log.Logger.Info("No new data found in this cycle",
+				if p.config.MaxFailures > 0 && failures >= p.config.MaxFailures {
+					return fmt.Errorf("%w: %d in a row for %s: %w", ErrTooManyFailures, failures, p.config.Table, err)
+				}
+
+				backoff := p.config.Backoff.Backoff(failures)
+				log.Logger.Warn("Backing off before next poll",
 					zap.String("table", p.config.Table),
-					zap.String("last_seen", lastSeen),
+					zap.Duration("backoff", backoff),
 				)
-				continue
-			}
-
-			lastRows := data.Rows[len(data.Rows)-1]
-
-			for i, col := range data.Columns {
-				if col.Name == p.config.DeltaCol {
-					switch v := lastRows[i].(type) {
-					case string:
-						lastSeen = v
-					case int, int32, int64, int8, int16:
-						lastSeen = fmt.Sprintf("%d", v)
-					case float64, float32:
-						lastSeen = fmt.Sprintf("%f", v)
-					default:
-						lastSeen = fmt.Sprintf("%v", v)
-					}
-					break
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(backoff):
 				}
+				ticker.Reset(p.config.Interval)
+				continue
 			}
 
-			log.Logger.Info("New data extracted",
-				zap.Int("rows", len(data.Rows)),
-				zap.String("table", p.config.Table),
-				zap.String("last_seen", lastSeen),
-			)
+			metrics.LastPollSuccess.WithLabelValues(p.config.Table).SetToCurrentTime()
 
-			if err := p.config.OnData(data); err != nil {
-				log.Logger.Error("Failed to process extracted data",
-					zap.Error(err),
+			if failures > 0 {
+				log.Logger.Info("Polling recovered",
 					zap.String("table", p.config.Table),
+					zap.Int("failed_cycles", failures),
 				)
-				continue
+				failures = 0
 			}
+
 		}
 	}
 }
+
+func (p *Poller) checkpoint(lastSeen string) {
+	if p.config.OnCheckpoint == nil {
+		return
+	}
+	if err := p.config.OnCheckpoint(lastSeen); err != nil {
+		log.Logger.Error("Failed to save checkpoint",
+			zap.Error(err),
+			zap.String("table", p.config.Table),
+		)
+	}
+}
+
+// poll runs one cycle and returns the new watermark and the rows loaded.
+func (p *Poller) poll(ctx context.Context, lastSeen string) (string, int, error) {
+	log.Logger.Info("Polling for new data",
+		zap.String("table", p.config.Table),
+		zap.String("last_seen", lastSeen),
+	)
+
+	var data *etl.TableData
+	err := etl.Retry(ctx, p.config.Table, p.config.Retry, func() error {
+		var err error
+		data, err = etl.ExtractTableDataSince(ctx, p.conn, p.config.Table, p.config.DeltaCol, lastSeen, p.config.Limit, p.config.Projection)
+		return err
+	})
+	if err != nil {
+		return lastSeen, 0, fmt.Errorf("error extracting table data: %w", err)
+	}
+
+	if len(data.Rows) == 0 {
+		log.Logger.Info("No new data found in this cycle",
+			zap.String("table", p.config.Table),
+			zap.String("last_seen", lastSeen),
+		)
+		return lastSeen, 0, nil
+	}
+
+	nextSeen, err := etl.LastDeltaValue(data, p.config.DeltaCol)
+	if err != nil {
+		return lastSeen, 0, fmt.Errorf("failed to determine last seen value: %w", err)
+	}
+
+	log.Logger.Info("New data extracted",
+		zap.Int("rows", len(data.Rows)),
+		zap.String("table", p.config.Table),
+		zap.String("last_seen", nextSeen),
+	)
+
+	if err := p.config.OnData(ctx, data); err != nil {
+		var interrupted *etl.InterruptedError
+		rows := 0
+		if errors.As(err, &interrupted) && interrupted.Inserted > 0 {
+			if partialSeen, seenErr := etl.DeltaValueAt(data, p.config.DeltaCol, interrupted.Inserted-1); seenErr == nil {
+				lastSeen, rows = partialSeen, interrupted.Inserted
+			}
+		}
+		return lastSeen, rows, fmt.Errorf("failed to process extracted data: %w", err)
+	}
+
+	return nextSeen, len(data.Rows), nil
+}
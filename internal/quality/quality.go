@@ -0,0 +1,324 @@
+package quality
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"pgtoch/internal/etl"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	CheckNotNull     = "not_null"
+	CheckRange       = "range"
+	CheckRegex       = "regex"
+	CheckInSet       = "in_set"
+	CheckUnique      = "unique_within_batch"
+	CheckMaxLength   = "max_length"
+	ActionDrop       = "drop"
+	ActionQuarantine = "quarantine"
+	ActionDefault    = "default"
+	ActionFail       = "fail"
+)
+
+var ErrRuleViolated = errors.New("data quality rule violated")
+
+// Rule is a single check on one column. Action decides what happens to a row
+// that fails the check.
+type Rule struct {
+	Column    string
+	Check     string
+	Min       *float64
+	Max       *float64
+	Pattern   string
+	Values    []string
+	MaxLength int
+	Action    string
+	Default   any
+}
+
+func (r Rule) Name() string {
+	return r.Column + ":" + r.Check
+}
+
+type Violation struct {
+	Rule   string
+	Column string
+	Action string
+	Count  int
+}
+
+type compiledRule struct {
+	Rule
+	re  *regexp.Regexp
+	set map[string]bool
+}
+
+// Checker evaluates rules against each batch before it is loaded. It is an
+// etl.Stage and keeps running violation counts across batches.
+type Checker struct {
+	table string
+	rules []compiledRule
+	sink  etl.DeadLetterSink
+
+	mu     sync.Mutex
+	counts []int
+}
+
+func New(table string, rules []Rule, sink etl.DeadLetterSink) (*Checker, error) {
+	c := &Checker{table: table, sink: sink, counts: make([]int, len(rules))}
+
+	for _, r := range rules {
+		if r.Column == "" {
+			return nil, fmt.Errorf("quality rule %q is missing a column", r.Check)
+		}
+		cr := compiledRule{Rule: r}
+
+		switch r.Check {
+		case CheckNotNull, CheckUnique:
+		case CheckRange:
+			if r.Min == nil && r.Max == nil {
+				return nil, fmt.Errorf("range rule on %s needs min or max", r.Column)
+			}
+		case CheckRegex:
+			re, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("regex rule on %s: %w", r.Column, err)
+			}
+			cr.re = re
+		case CheckInSet:
+			if len(r.Values) == 0 {
+				return nil, fmt.Errorf("in_set rule on %s needs values", r.Column)
+			}
+			cr.set = make(map[string]bool, len(r.Values))
+			for _, v := range r.Values {
+				cr.set[v] = true
+			}
+		case CheckMaxLength:
+			if r.MaxLength <= 0 {
+				return nil, fmt.Errorf("max_length rule on %s needs a positive maxlength", r.Column)
+			}
+		default:
+			return nil, fmt.Errorf("unknown quality rule %q on %s", r.Check, r.Column)
+		}
+
+		switch r.Action {
+		case ActionDrop, ActionFail:
+		case ActionQuarantine:
+			if sink == nil {
+				return nil, fmt.Errorf("%s rule on %s quarantines rows but no dead-letter sink is configured", r.Check, r.Column)
+			}
+		case ActionDefault:
+			if r.Default == nil {
+				return nil, fmt.Errorf("%s rule on %s replaces values but has no default", r.Check, r.Column)
+			}
+		case "":
+			return nil, fmt.Errorf("%s rule on %s is missing an action (drop, quarantine, default or fail)", r.Check, r.Column)
+		default:
+			return nil, fmt.Errorf("unknown action %q for %s rule on %s", r.Action, r.Check, r.Column)
+		}
+
+		c.rules = append(c.rules, cr)
+	}
+
+	return c, nil
+}
+
+func (c *Checker) Apply(ctx context.Context, td *etl.TableData) (*etl.TableData, error) {
+	if len(c.rules) == 0 || len(td.Rows) == 0 {
+		return td, nil
+	}
+
+	index := make(map[string]int, len(td.Columns))
+	for i, col := range td.Columns {
+		index[col.Name] = i
+	}
+	cols := make([]int, len(c.rules))
+	for i, r := range c.rules {
+		idx, ok := index[r.Column]
+		if !ok {
+			return nil, fmt.Errorf("quality rule %s: column %s not found in %s", r.Name(), r.Column, c.table)
+		}
+		cols[i] = idx
+	}
+
+	seen := make([]map[string]bool, len(c.rules))
+	counts := make([]int, len(c.rules))
+	defer c.record(counts)
+
+	rows := make([][]any, 0, len(td.Rows))
+	var quarantined []etl.RejectedRow
+
+	for n, row := range td.Rows {
+		keep := true
+		copied := false
+
+		for i, r := range c.rules {
+			v := row[cols[i]]
+			if r.Check == CheckUnique && seen[i] == nil {
+				seen[i] = make(map[string]bool)
+			}
+			if r.passes(v, seen[i]) {
+				continue
+			}
+			counts[i]++
+
+			switch r.Action {
+			case ActionFail:
+				return nil, fmt.Errorf("%w: %s in %s (row %d, value %v)", ErrRuleViolated, r.Name(), c.table, n+1, v)
+			case ActionDrop:
+				keep = false
+			case ActionQuarantine:
+				keep = false
+				quarantined = append(quarantined, etl.RejectedRow{
+					Row: row,
+					Err: fmt.Errorf("%w: %s (value %v)", ErrRuleViolated, r.Name(), v),
+				})
+			case ActionDefault:
+				if !copied {
+					row = append([]any(nil), row...)
+					copied = true
+				}
+				row[cols[i]] = r.Default
+			}
+			if !keep {
+				break
+			}
+		}
+
+		if keep {
+			rows = append(rows, row)
+		}
+	}
+
+	if len(quarantined) > 0 {
+		if err := c.sink.Write(ctx, c.table, etl.GetColumnNames(td.Columns), quarantined); err != nil {
+			return nil, fmt.Errorf("failed to quarantine rows: %w", err)
+		}
+	}
+
+	return &etl.TableData{Columns: td.Columns, Rows: rows}, nil
+}
+
+func (c *Checker) record(counts []int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, n := range counts {
+		c.counts[i] += n
+	}
+}
+
+func (c *Checker) Table() string {
+	return c.table
+}
+
+// Violations returns the number of failed checks per rule so far, in the
+// order the rules were configured.
+func (c *Checker) Violations() []Violation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := make([]Violation, len(c.rules))
+	for i, r := range c.rules {
+		out[i] = Violation{Rule: r.Name(), Column: r.Column, Action: r.Action, Count: c.counts[i]}
+	}
+	return out
+}
+
+func (r compiledRule) passes(v any, seen map[string]bool) bool {
+	if v == nil {
+		return r.Check != CheckNotNull
+	}
+
+	switch r.Check {
+	case CheckRange:
+		f, ok := toFloat(v)
+		if !ok {
+			return false
+		}
+		if r.Min != nil && f < *r.Min {
+			return false
+		}
+		if r.Max != nil && f > *r.Max {
+			return false
+		}
+	case CheckRegex:
+		return r.re.MatchString(toString(v))
+	case CheckInSet:
+		return r.set[toString(v)]
+	case CheckMaxLength:
+		return utf8.RuneCountInString(toString(v)) <= r.MaxLength
+	case CheckUnique:
+		key := toString(v)
+		if seen[key] {
+			return false
+		}
+		seen[key] = true
+	}
+	return true
+}
+
+func toFloat(v any) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, !math.IsNaN(v)
+	case pgtype.Numeric:
+		f, err := v.Float64Value()
+		if err != nil || !f.Valid {
+			return 0, false
+		}
+		return f.Float64, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+func toString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case pgtype.Numeric:
+		f, err := v.Float64Value()
+		if err != nil || !f.Valid {
+			return ""
+		}
+		return strconv.FormatFloat(f.Float64, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,13 @@
+// Package quote writes values as single-quoted strings. Postgres keyword/value
+// DSNs and ClickHouse string literals share the same escaping: a backslash
+// escapes the next character.
+package quote
+
+import "strings"
+
+// String returns s in single quotes with backslashes and quotes escaped.
+func String(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `'`, `\'`)
+	return "'" + s + "'"
+}
@@ -0,0 +1,81 @@
+package redact
+
+import "testing"
+
+func TestString(t *testing.T) {
+	AddSecret("hunter2-from-file", "abc")
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "postgres url",
+			in:   "postgres://app:s3cret@db:5432/app",
+			want: "postgres://app:xxxxx@db:5432/app",
+		},
+		{
+			name: "clickhouse url",
+			in:   "failed to connect to clickhouse://default:pw@ch:9000/default: timeout",
+			want: "failed to connect to clickhouse://default:xxxxx@ch:9000/default: timeout",
+		},
+		{
+			name: "unescaped @ in the password",
+			in:   "postgres://app:p@ss@db/app",
+			want: "postgres://app:xxxxx@db/app",
+		},
+		{
+			name: "url without password",
+			in:   "postgres://app@db/app and http://ch:8123/?query=1",
+			want: "postgres://app@db/app and http://ch:8123/?query=1",
+		},
+		{
+			name: "keyword dsn",
+			in:   "host=db user=app password=s3cret dbname=app",
+			want: "host=db user=app password=xxxxx dbname=app",
+		},
+		{
+			name: "quoted keyword value",
+			in:   `host=db password='it\'s a secret' dbname=app`,
+			want: "host=db password=xxxxx dbname=app",
+		},
+		{
+			name: "spaces around the equals sign",
+			in:   "PASSWORD = s3cret",
+			want: "PASSWORD = xxxxx",
+		},
+		{
+			name: "query parameter",
+			in:   "clickhouse://ch:9000/db?username=app&password=s3cret&secure=true",
+			want: "clickhouse://ch:9000/db?username=app&password=xxxxx&secure=true",
+		},
+		{
+			name: "pwd and passwd",
+			in:   "pwd=one passwd=two",
+			want: "pwd=xxxxx passwd=xxxxx",
+		},
+		{
+			name: "registered secret anywhere",
+			in:   "auth failed with token hunter2-from-file",
+			want: "auth failed with token xxxxx",
+		},
+		{
+			name: "short secrets are not registered",
+			in:   "abc def",
+			want: "abc def",
+		},
+		{
+			name: "nothing to mask",
+			in:   "inserted 500 rows into users",
+			want: "inserted 500 rows into users",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := String(tt.in); got != tt.want {
+				t.Errorf("String(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
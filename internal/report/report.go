@@ -0,0 +1,97 @@
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"pgtoch/internal/redact"
+	"time"
+)
+
+const (
+	StatusSucceeded   = "succeeded"
+	StatusFailed      = "failed"
+	StatusInterrupted = "interrupted"
+)
+
+// Report is the machine-readable summary of one command run.
+type Report struct {
+	Command    string         `json:"command"`
+	Status     string         `json:"status"`
+	ExitCode   int            `json:"exit_code"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt time.Time      `json:"finished_at"`
+	Tables     []*TableReport `json:"tables"`
+	Warnings   []string       `json:"warnings"`
+	Errors     []string       `json:"errors,omitempty"`
+}
+
+type TableReport struct {
+	Table             string         `json:"table"`
+	RowsRead          int            `json:"rows_read"`
+	RowsWritten       int            `json:"rows_written"`
+	RowsRejected      int            `json:"rows_rejected"`
+	RowsDropped       int            `json:"rows_dropped"`
+	Retries           int            `json:"retries"`
+	Bytes             int64          `json:"bytes"`
+	DDL               []string       `json:"ddl,omitempty"`
+	Watermark         string         `json:"watermark,omitempty"`
+	Output            string         `json:"output,omitempty"`
+	QualityViolations map[string]int `json:"quality_violations,omitempty"`
+}
+
+func New(command string) *Report {
+	return &Report{
+		Command:   command,
+		StartedAt: time.Now().UTC(),
+		Tables:    []*TableReport{},
+		Warnings:  []string{},
+	}
+}
+
+// Table returns the entry for table, adding it on first use.
+func (r *Report) Table(table string) *TableReport {
+	for _, t := range r.Tables {
+		if t.Table == table {
+			return t
+		}
+	}
+	t := &TableReport{Table: table}
+	r.Tables = append(r.Tables, t)
+	return t
+}
+
+func (r *Report) Warn(msg string) {
+	r.Warnings = append(r.Warnings, redact.String(msg))
+}
+
+// Finish stamps the end time and outcome. Errors holds err followed by each
+// error it wraps, outermost first, with credentials masked.
+func (r *Report) Finish(status string, exitCode int, err error) {
+	r.FinishedAt = time.Now().UTC()
+	r.Status = status
+	r.ExitCode = exitCode
+	for ; err != nil; err = errors.Unwrap(err) {
+		r.Errors = append(r.Errors, redact.String(err.Error()))
+	}
+}
+
+func (r *Report) Encode(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func (r *Report) WriteFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create report file: %w", err)
+	}
+	if err := r.Encode(f); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return f.Close()
+}
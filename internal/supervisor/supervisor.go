@@ -0,0 +1,102 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"pgtoch/internal/etl"
+	"pgtoch/internal/log"
+	"pgtoch/internal/poller"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type Task struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+type Supervisor struct {
+	tasks   []Task
+	backoff etl.RetryConfig
+}
+
+func New(backoff etl.RetryConfig) *Supervisor {
+	return &Supervisor{backoff: backoff}
+}
+
+func (s *Supervisor) Add(name string, run func(ctx context.Context) error) {
+	s.tasks = append(s.tasks, Task{Name: name, Run: run})
+}
+
+// Run runs every task until ctx is cancelled, restarting those that fail. A
+// task that fails permanently is not restarted; Run returns the errors of
+// those tasks once all of them have stopped.
+func (s *Supervisor) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(s.tasks))
+	for i, task := range s.tasks {
+		wg.Add(1)
+		go func(i int, task Task) {
+			defer wg.Done()
+			errs[i] = s.supervise(ctx, task)
+		}(i, task)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// permanent reports whether restarting a task that failed with err would
+// fail the same way: a poller that hit its max_failures limit, or an error
+// the retry classifier does not consider transient, such as a missing table
+// or an invalid setting.
+func permanent(err error) bool {
+	return errors.Is(err, poller.ErrTooManyFailures) || !etl.IsRetriable(err)
+}
+
+func (s *Supervisor) supervise(ctx context.Context, task Task) error {
+	attempt := 0
+	for {
+		started := time.Now()
+		err := task.Run(ctx)
+
+		if ctx.Err() != nil {
+			log.Logger.Info("Pipeline stopped", zap.String("pipeline", task.Name))
+			return nil
+		}
+
+		if err == nil || errors.Is(err, context.Canceled) {
+			log.Logger.Info("Pipeline finished", zap.String("pipeline", task.Name))
+			return nil
+		}
+
+		if permanent(err) {
+			log.Logger.Error("Pipeline failed, not restarting",
+				zap.String("pipeline", task.Name),
+				zap.Error(err),
+			)
+			return fmt.Errorf("pipeline %s: %w", task.Name, err)
+		}
+
+		if time.Since(started) > s.backoff.MaxDelay {
+			attempt = 0
+		}
+		attempt++
+		backoff := s.backoff.Backoff(attempt)
+
+		log.Logger.Error("Pipeline failed, restarting",
+			zap.String("pipeline", task.Name),
+			zap.Error(err),
+			zap.Int("attempt", attempt),
+			zap.Duration("backoff", backoff),
+		)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+	}
+}
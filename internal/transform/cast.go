@@ -0,0 +1,169 @@
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+func castValue(v any, pgType string) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	switch pgType {
+	case "smallint":
+		i, err := toInt(v, 16)
+		return int16(i), err
+	case "integer", "serial":
+		i, err := toInt(v, 32)
+		return int32(i), err
+	case "bigint", "bigserial":
+		return toInt(v, 64)
+	case "real":
+		f, err := toFloat(v)
+		return float32(f), err
+	case "numeric", "decimal", "double precision":
+		return toFloat(v)
+	case "boolean":
+		switch v := v.(type) {
+		case bool:
+			return v, nil
+		case string:
+			return strconv.ParseBool(strings.TrimSpace(v))
+		}
+		i, err := toInt(v, 64)
+		return i != 0, err
+	case "date", "timestamp", "timestamp without time zone", "timestamp with time zone":
+		switch v := v.(type) {
+		case time.Time:
+			return v, nil
+		case string:
+			for _, layout := range timeLayouts {
+				if t, err := time.Parse(layout, strings.TrimSpace(v)); err == nil {
+					return t, nil
+				}
+			}
+			return nil, fmt.Errorf("cannot parse %q as a time", v)
+		}
+		return nil, fmt.Errorf("cannot convert %T to a time", v)
+	default:
+		return toString(v), nil
+	}
+}
+
+func toInt(v any, bits int) (int64, error) {
+	var i int64
+	switch v := v.(type) {
+	case int:
+		i = int64(v)
+	case int8:
+		i = int64(v)
+	case int16:
+		i = int64(v)
+	case int32:
+		i = int64(v)
+	case int64:
+		i = v
+	case uint8:
+		i = int64(v)
+	case uint16:
+		i = int64(v)
+	case uint32:
+		i = int64(v)
+	case bool:
+		if v {
+			i = 1
+		}
+	case string:
+		parsed, err := strconv.ParseInt(strings.TrimSpace(v), 10, bits)
+		if err != nil {
+			return 0, err
+		}
+		return parsed, nil
+	default:
+		f, err := toFloat(v)
+		if err != nil {
+			return 0, err
+		}
+		if f != math.Trunc(f) {
+			return 0, fmt.Errorf("%v is not a whole number", f)
+		}
+		i = int64(f)
+	}
+
+	if bits < 64 {
+		limit := int64(1) << (bits - 1)
+		if i < -limit || i >= limit {
+			return 0, fmt.Errorf("%d out of range for a %d-bit integer", i, bits)
+		}
+	}
+	return i, nil
+}
+
+func toFloat(v any) (float64, error) {
+	switch v := v.(type) {
+	case float32:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	case int16:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case pgtype.Numeric:
+		f, err := v.Float64Value()
+		if err != nil {
+			return 0, err
+		}
+		if !f.Valid {
+			return 0, fmt.Errorf("invalid numeric")
+		}
+		return f.Float64, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	default:
+		return 0, fmt.Errorf("cannot convert %T to a number", v)
+	}
+}
+
+func toString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case pgtype.Numeric:
+		f, err := v.Float64Value()
+		if err != nil || !f.Valid {
+			return ""
+		}
+		return strconv.FormatFloat(f.Float64, 'f', -1, 64)
+	case map[string]any, []any:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(b)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,292 @@
+package transform
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"pgtoch/internal/etl"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	ExprNow         = "now()"
+	ExprSourceTable = "source_table()"
+)
+
+var placeholder = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+type Mask struct {
+	Column string
+	Keep   int
+}
+
+// Computed adds a column to every row. Expression is now(), source_table() or
+// a string template where {column} is replaced by that source column's value.
+type Computed struct {
+	Name       string
+	Expression string
+}
+
+type Spec struct {
+	Rename   map[string]string
+	Drop     []string
+	Cast     map[string]string
+	Mask     []Mask
+	Hash     []string
+	Salt     string
+	Computed []Computed
+}
+
+func (s Spec) Empty() bool {
+	return len(s.Rename) == 0 && len(s.Drop) == 0 && len(s.Cast) == 0 &&
+		len(s.Mask) == 0 && len(s.Hash) == 0 && len(s.Computed) == 0
+}
+
+// Transformer applies a Spec to every batch. Casts, masks, hashes and computed
+// expressions refer to source column names; drops and renames are applied
+// last and computed columns are appended at the end.
+type Transformer struct {
+	table string
+	spec  Spec
+	drop  map[string]bool
+	mask  map[string]int
+	hash  map[string]bool
+}
+
+func New(table string, spec Spec) (*Transformer, error) {
+	t := &Transformer{
+		table: table,
+		spec:  spec,
+		drop:  make(map[string]bool, len(spec.Drop)),
+		mask:  make(map[string]int, len(spec.Mask)),
+		hash:  make(map[string]bool, len(spec.Hash)),
+	}
+
+	for _, col := range spec.Drop {
+		t.drop[col] = true
+	}
+	for col, pgType := range spec.Cast {
+		if _, ok := etl.ClickHouseType(pgType); !ok {
+			return nil, fmt.Errorf("cast of %s: unsupported type %q", col, pgType)
+		}
+	}
+	for _, m := range spec.Mask {
+		if m.Keep < 0 {
+			return nil, fmt.Errorf("mask of %s: keep must not be negative", m.Column)
+		}
+		t.mask[m.Column] = m.Keep
+	}
+	for _, col := range spec.Hash {
+		if _, ok := t.mask[col]; ok {
+			return nil, fmt.Errorf("column %s is both masked and hashed", col)
+		}
+		t.hash[col] = true
+	}
+	if len(spec.Hash) > 0 && spec.Salt == "" {
+		return nil, fmt.Errorf("hashing columns requires a salt")
+	}
+	for _, c := range spec.Computed {
+		if c.Name == "" || c.Expression == "" {
+			return nil, fmt.Errorf("computed columns need a name and an expression")
+		}
+	}
+
+	return t, nil
+}
+
+// TargetName returns the name a source column has after the transform, or
+// false when the column is dropped.
+func (t *Transformer) TargetName(col string) (string, bool) {
+	if t.drop[col] {
+		return "", false
+	}
+	if name, ok := t.spec.Rename[col]; ok {
+		return name, true
+	}
+	return col, true
+}
+
+func (t *Transformer) Schema(cols []etl.Column) ([]etl.Column, error) {
+	known := make(map[string]bool, len(cols))
+	for _, col := range cols {
+		known[col.Name] = true
+	}
+
+	for col := range t.drop {
+		if !known[col] {
+			return nil, fmt.Errorf("drop: column %s not found in %s", col, t.table)
+		}
+	}
+	for col := range t.spec.Rename {
+		if !known[col] {
+			return nil, fmt.Errorf("rename: column %s not found in %s", col, t.table)
+		}
+	}
+	for col := range t.spec.Cast {
+		if !known[col] {
+			return nil, fmt.Errorf("cast: column %s not found in %s", col, t.table)
+		}
+	}
+	for col := range t.mask {
+		if !known[col] {
+			return nil, fmt.Errorf("mask: column %s not found in %s", col, t.table)
+		}
+	}
+	for col := range t.hash {
+		if !known[col] {
+			return nil, fmt.Errorf("hash: column %s not found in %s", col, t.table)
+		}
+	}
+
+	out := make([]etl.Column, 0, len(cols)+len(t.spec.Computed))
+	names := make(map[string]bool, cap(out))
+	add := func(col etl.Column) error {
+		if names[col.Name] {
+			return fmt.Errorf("transform of %s produces column %s twice", t.table, col.Name)
+		}
+		names[col.Name] = true
+		out = append(out, col)
+		return nil
+	}
+
+	for _, col := range cols {
+		name, keep := t.TargetName(col.Name)
+		if !keep {
+			continue
+		}
+		colType := col.Type
+		if pgType, ok := t.spec.Cast[col.Name]; ok {
+			colType = pgType
+		}
+		if _, ok := t.mask[col.Name]; ok {
+			colType = "text"
+		}
+		if t.hash[col.Name] {
+			colType = "text"
+		}
+		if err := add(etl.Column{Name: name, Type: colType}); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, c := range t.spec.Computed {
+		colType := "text"
+		switch c.Expression {
+		case ExprNow:
+			colType = "timestamp with time zone"
+		case ExprSourceTable:
+		default:
+			for _, m := range placeholder.FindAllStringSubmatch(c.Expression, -1) {
+				if !known[m[1]] {
+					return nil, fmt.Errorf("computed column %s: column %s not found in %s", c.Name, m[1], t.table)
+				}
+			}
+		}
+		if err := add(etl.Column{Name: c.Name, Type: colType}); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(out) == 0 {
+		return nil, fmt.Errorf("transform of %s leaves no columns", t.table)
+	}
+	return out, nil
+}
+
+func (t *Transformer) Apply(ctx context.Context, td *etl.TableData) (*etl.TableData, error) {
+	outCols, err := t.Schema(td.Columns)
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]int, len(td.Columns))
+	for i, col := range td.Columns {
+		index[col.Name] = i
+	}
+
+	now := time.Now().UTC()
+	rows := make([][]any, len(td.Rows))
+
+	for n, row := range td.Rows {
+		out := make([]any, 0, len(outCols))
+
+		for i, col := range td.Columns {
+			if t.drop[col.Name] {
+				continue
+			}
+			v, err := t.value(col, row[i])
+			if err != nil {
+				return nil, fmt.Errorf("row %d: %w", n+1, err)
+			}
+			out = append(out, v)
+		}
+
+		for _, c := range t.spec.Computed {
+			switch c.Expression {
+			case ExprNow:
+				out = append(out, now)
+			case ExprSourceTable:
+				out = append(out, t.table)
+			default:
+				out = append(out, placeholder.ReplaceAllStringFunc(c.Expression, func(m string) string {
+					v := row[index[m[1:len(m)-1]]]
+					if v == nil {
+						return ""
+					}
+					return toString(v)
+				}))
+			}
+		}
+
+		rows[n] = out
+	}
+
+	return &etl.TableData{Columns: outCols, Rows: rows}, nil
+}
+
+func (t *Transformer) value(col etl.Column, v any) (any, error) {
+	if pgType, ok := t.spec.Cast[col.Name]; ok {
+		cast, err := castValue(v, pgType)
+		if err != nil {
+			return nil, fmt.Errorf("cast of %s to %s: %w", col.Name, pgType, err)
+		}
+		v = cast
+	}
+	if v == nil {
+		return nil, nil
+	}
+	if keep, ok := t.mask[col.Name]; ok {
+		return mask(toString(v), keep), nil
+	}
+	if t.hash[col.Name] {
+		return hash(t.spec.Salt, toString(v)), nil
+	}
+	return v, nil
+}
+
+// mask hides all but the last keep characters. For email addresses the
+// domain stays readable and keep applies to the local part.
+func mask(s string, keep int) string {
+	if at := strings.LastIndex(s, "@"); at > 0 {
+		return maskTail(s[:at], keep) + s[at:]
+	}
+	return maskTail(s, keep)
+}
+
+func maskTail(s string, keep int) string {
+	r := []rune(s)
+	if keep >= len(r) {
+		return s
+	}
+	return strings.Repeat("*", len(r)-keep) + string(r[len(r)-keep:])
+}
+
+func hash(salt, s string) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil))
+}
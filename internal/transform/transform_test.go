@@ -0,0 +1,31 @@
+package transform
+
+import "testing"
+
+func TestMask(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		keep int
+		want string
+	}{
+		{name: "keep last four", in: "5551234567", keep: 4, want: "******4567"},
+		{name: "keep nothing", in: "secret", keep: 0, want: "******"},
+		{name: "keep everything", in: "1234", keep: 4, want: "1234"},
+		{name: "keep more than the length", in: "12", keep: 4, want: "12"},
+		{name: "multibyte characters", in: "zürich", keep: 2, want: "****ch"},
+		{name: "empty", in: "", keep: 2, want: ""},
+		{name: "email keeps the domain", in: "jane.doe@example.com", keep: 0, want: "********@example.com"},
+		{name: "email applies keep to the local part", in: "jane.doe@example.com", keep: 3, want: "*****doe@example.com"},
+		{name: "email keep covers the local part", in: "jd@example.com", keep: 4, want: "jd@example.com"},
+		{name: "email with @ in the local part", in: `"a@b"@example.com`, keep: 1, want: `****"@example.com`},
+		{name: "leading @ is not an email", in: "@handle", keep: 2, want: "*****le"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mask(tt.in, tt.keep); got != tt.want {
+				t.Errorf("mask(%q, %d) = %q, want %q", tt.in, tt.keep, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,425 @@
+package validate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"pgtoch/internal/etl"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// pow64 is 2^64; Postgres sums the row hashes exactly and ClickHouse wraps
+// its UInt64 sum, so the Postgres sum is taken modulo pow64.
+const pow64 = "18446744073709551616"
+
+type keyRange struct {
+	after []string
+	upTo  []string
+}
+
+type checksum struct {
+	rows uint64
+	sum  uint64
+}
+
+type RangeResult struct {
+	After          []string `json:"after,omitempty"`
+	UpTo           []string `json:"up_to,omitempty"`
+	PostgresRows   uint64   `json:"postgres_rows"`
+	ClickHouseRows uint64   `json:"clickhouse_rows"`
+}
+
+type RowDiff struct {
+	Key  []string `json:"key"`
+	Kind string   `json:"kind"`
+}
+
+type checker struct {
+	pg     etl.Querier
+	ch     *sql.DB
+	table  string
+	target string
+	// cols are hashed, columns both sides cannot render alike are left out
+	cols  []Column
+	key   []string
+	types map[string]string
+	// targets are the ClickHouse names of the columns
+	targets map[string]string
+}
+
+// newChecker returns the checker of cols and the columns it skips.
+func newChecker(pg etl.Querier, ch *sql.DB, table, target string, cols []Column, key []string) (*checker, []string) {
+	c := &checker{
+		pg:      pg,
+		ch:      ch,
+		table:   table,
+		target:  target,
+		key:     key,
+		types:   make(map[string]string, len(cols)),
+		targets: make(map[string]string, len(cols)),
+	}
+	var skipped []string
+	for _, col := range cols {
+		c.types[col.Name] = col.Type
+		c.targets[col.Name] = col.Target
+		if !hashable(col.Type) {
+			skipped = append(skipped, col.Name)
+			continue
+		}
+		c.cols = append(c.cols, col)
+	}
+	return c, skipped
+}
+
+func (c *checker) pgKeyList() string {
+	quoted := make([]string, len(c.key))
+	for i, k := range c.key {
+		quoted[i] = pgx.Identifier{k}.Sanitize()
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func (c *checker) chKeyList() string {
+	quoted := make([]string, len(c.key))
+	for i, k := range c.key {
+		quoted[i] = etl.QuoteIdentifier(c.targets[k])
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func (c *checker) pgWhere(r keyRange) (string, []any) {
+	var conds []string
+	var args []any
+	bound := func(op string, values []string) {
+		placeholders := make([]string, len(values))
+		for i, v := range values {
+			args = append(args, v)
+			placeholders[i] = fmt.Sprintf("$%d::text::%s", len(args), c.types[c.key[i]])
+		}
+		conds = append(conds, fmt.Sprintf("(%s) %s (%s)", c.pgKeyList(), op, strings.Join(placeholders, ", ")))
+	}
+	if r.after != nil {
+		bound(">", r.after)
+	}
+	if r.upTo != nil {
+		bound("<=", r.upTo)
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+func (c *checker) chWhere(r keyRange) (string, []any) {
+	var conds []string
+	var args []any
+	bound := func(op string, values []string) {
+		placeholders := make([]string, len(values))
+		for i, v := range values {
+			args = append(args, v)
+			placeholders[i] = c.chCast(c.key[i])
+		}
+		conds = append(conds, fmt.Sprintf("(%s) %s (%s)", c.chKeyList(), op, strings.Join(placeholders, ", ")))
+	}
+	if r.after != nil {
+		bound(">", r.after)
+	}
+	if r.upTo != nil {
+		bound("<=", r.upTo)
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+func (c *checker) chCast(col string) string {
+	chType, _ := etl.ClickHouseType(c.types[col])
+	switch {
+	case strings.HasPrefix(chType, "DateTime"):
+		return "parseDateTime64BestEffort(?, 6)"
+	case chType == "Date":
+		return "toDate(parseDateTimeBestEffort(?))"
+	case chType == "UUID":
+		return "toUUID(?)"
+	case chType == "":
+		return "?"
+	default:
+		return fmt.Sprintf("CAST(? AS %s)", chType)
+	}
+}
+
+func (c *checker) boundaries(ctx context.Context, n int) ([][]string, error) {
+	textKeys := make([]string, len(c.key))
+	descKeys := make([]string, len(c.key))
+	for i, k := range c.key {
+		textKeys[i] = pgx.Identifier{k}.Sanitize() + "::text"
+		descKeys[i] = pgx.Identifier{k}.Sanitize() + " DESC"
+	}
+	query := fmt.Sprintf(
+		"SELECT DISTINCT ON (tile) %s FROM (SELECT %s, ntile($1) OVER (ORDER BY %s) AS tile FROM %s) s ORDER BY tile, %s",
+		strings.Join(textKeys, ", "), c.pgKeyList(), c.pgKeyList(), pgx.Identifier{c.table}.Sanitize(), strings.Join(descKeys, ", "),
+	)
+	rows, err := c.pg.Query(ctx, query, n)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute key ranges: %w", err)
+	}
+	defer rows.Close()
+
+	var bounds [][]string
+	for rows.Next() {
+		values := make([]string, len(c.key))
+		dest := make([]any, len(c.key))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to scan key range: %w", err)
+		}
+		bounds = append(bounds, values)
+	}
+	return bounds, rows.Err()
+}
+
+func (c *checker) median(ctx context.Context, r keyRange, count uint64) ([]string, error) {
+	textKeys := make([]string, len(c.key))
+	for i, k := range c.key {
+		textKeys[i] = pgx.Identifier{k}.Sanitize() + "::text"
+	}
+	where, args := c.pgWhere(r)
+	args = append(args, count/2)
+	query := fmt.Sprintf("SELECT %s FROM %s%s ORDER BY %s OFFSET $%d LIMIT 1",
+		strings.Join(textKeys, ", "), pgx.Identifier{c.table}.Sanitize(), where, c.pgKeyList(), len(args))
+
+	rows, err := c.pg.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to split key range: %w", err)
+	}
+	defer rows.Close()
+
+	values := make([]string, len(c.key))
+	dest := make([]any, len(c.key))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, fmt.Errorf("failed to scan split key: %w", err)
+	}
+	return values, rows.Err()
+}
+
+// hashable reports whether Postgres and ClickHouse render a column of
+// pgType alike. JSON is re-encoded on load and bytea is loaded as a UUID.
+func hashable(pgType string) bool {
+	switch pgType {
+	case "json", "jsonb", "bytea":
+		return false
+	}
+	return true
+}
+
+// pgValue and chValue render col as text both databases agree on. Numeric
+// columns are loaded as Float64, so they compare by the exact bits of the
+// float64 ClickHouse stores rather than a rounded decimal. Timestamps
+// compare in Unix seconds, ClickHouse DateTime keeps no more.
+func pgValue(col Column) string {
+	id := pgx.Identifier{col.Name}.Sanitize()
+	switch col.Type {
+	case "numeric", "decimal", "double precision":
+		return fmt.Sprintf("('x' || encode(float8send(%s::float8), 'hex'))::bit(64)::bigint::text", id)
+	case "real":
+		return fmt.Sprintf("('x' || encode(float4send(%s), 'hex'))::bit(32)::integer::text", id)
+	case "date":
+		return fmt.Sprintf("to_char(%s, 'YYYY-MM-DD')", id)
+	case "timestamp", "timestamp without time zone", "timestamp with time zone":
+		return fmt.Sprintf("floor(extract(epoch FROM %s))::bigint::text", id)
+	case "char", "character":
+		return fmt.Sprintf("rtrim(%s)", id)
+	default:
+		return id + "::text"
+	}
+}
+
+func chValue(col Column) string {
+	id := etl.QuoteIdentifier(col.Target)
+	switch col.Type {
+	case "numeric", "decimal", "double precision":
+		return fmt.Sprintf("toString(reinterpretAsInt64(toFloat64(%s)))", id)
+	case "real":
+		return fmt.Sprintf("toString(reinterpretAsInt32(toFloat32(%s)))", id)
+	case "timestamp", "timestamp without time zone", "timestamp with time zone":
+		return fmt.Sprintf("toString(toUnixTimestamp(%s))", id)
+	case "char", "character":
+		return fmt.Sprintf("trimRight(%s)", id)
+	default:
+		return fmt.Sprintf("toString(%s)", id)
+	}
+}
+
+// pgRow and chRow are the text of a row that is hashed: the values marked
+// with "=" so NULL differs from an empty string, joined by a unit separator.
+func (c *checker) pgRow() string {
+	parts := make([]string, len(c.cols))
+	for i, col := range c.cols {
+		parts[i] = fmt.Sprintf("coalesce('=' || %s, '')", pgValue(col))
+	}
+	return strings.Join(parts, " || chr(31) || ")
+}
+
+func (c *checker) chRow() string {
+	parts := make([]string, len(c.cols))
+	for i, col := range c.cols {
+		parts[i] = fmt.Sprintf("ifNull(concat('=', %s), '')", chValue(col))
+	}
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	return "concat(" + strings.Join(parts, ", char(31), ") + ")"
+}
+
+// checksums counts the rows of r on both sides and sums the first 64 bits
+// of their md5, without reading the rows.
+func (c *checker) checksums(ctx context.Context, r keyRange) (checksum, checksum, error) {
+	var pgSum, chSum checksum
+
+	where, args := c.pgWhere(r)
+	hash := fmt.Sprintf("('x' || substr(md5(%s), 1, 16))::bit(64)::bigint", c.pgRow())
+	query := fmt.Sprintf("SELECT count(*), coalesce(mod(mod(sum(%s), %s) + %s, %s), 0)::text FROM %s%s",
+		hash, pow64, pow64, pow64, pgx.Identifier{c.table}.Sanitize(), where)
+	rows, err := c.pg.Query(ctx, query, args...)
+	if err != nil {
+		return pgSum, chSum, fmt.Errorf("failed to checksum postgres range: %w", err)
+	}
+	var count int64
+	var sum string
+	if rows.Next() {
+		err = rows.Scan(&count, &sum)
+	}
+	rows.Close()
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		return pgSum, chSum, fmt.Errorf("failed to scan postgres checksum: %w", err)
+	}
+	pgSum.rows = uint64(count)
+	if pgSum.sum, err = strconv.ParseUint(sum, 10, 64); err != nil {
+		return pgSum, chSum, fmt.Errorf("failed to parse postgres checksum: %w", err)
+	}
+
+	where, args = c.chWhere(r)
+	hash = fmt.Sprintf("reinterpretAsUInt64(reverse(substring(MD5(%s), 1, 8)))", c.chRow())
+	query = fmt.Sprintf("SELECT count(), sum(%s) FROM %s%s", hash, etl.QuoteIdentifier(c.target), where)
+	if err := c.ch.QueryRowContext(ctx, query, args...).Scan(&chSum.rows, &chSum.sum); err != nil {
+		return pgSum, chSum, fmt.Errorf("failed to checksum clickhouse range: %w", err)
+	}
+	return pgSum, chSum, nil
+}
+
+func (c *checker) keyColumns() []Column {
+	cols := make([]Column, len(c.key))
+	for i, k := range c.key {
+		cols[i] = Column{Column: etl.Column{Name: k, Type: c.types[k]}, Target: c.targets[k]}
+	}
+	return cols
+}
+
+// rowDiffs compares the keys and row hashes of r, stopping after limit
+// differences. Keys match on their rendered value and are reported as text.
+func (c *checker) rowDiffs(ctx context.Context, r keyRange, limit int) ([]RowDiff, error) {
+	keyCols := c.keyColumns()
+	n := len(keyCols)
+
+	var selects []string
+	for _, col := range keyCols {
+		selects = append(selects, pgValue(col))
+	}
+	for _, col := range keyCols {
+		selects = append(selects, pgx.Identifier{col.Name}.Sanitize()+"::text")
+	}
+	where, args := c.pgWhere(r)
+	query := fmt.Sprintf("SELECT %s, md5(%s) FROM %s%s",
+		strings.Join(selects, ", "), c.pgRow(), pgx.Identifier{c.table}.Sanitize(), where)
+
+	rows, err := c.pg.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read postgres row hashes: %w", err)
+	}
+	pgHashes := make(map[string]string)
+	pgKeys := make(map[string][]string)
+	values := make([]string, 2*n+1)
+	dest := make([]any, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan postgres row hash: %w", err)
+		}
+		id := strings.Join(values[:n], "\x1f")
+		pgHashes[id] = values[2*n]
+		pgKeys[id] = slices.Clone(values[n : 2*n])
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read postgres row hashes: %w", err)
+	}
+
+	selects = selects[:0]
+	for _, col := range keyCols {
+		selects = append(selects, chValue(col))
+	}
+	for _, col := range keyCols {
+		selects = append(selects, fmt.Sprintf("toString(%s)", etl.QuoteIdentifier(col.Target)))
+	}
+	where, args = c.chWhere(r)
+	query = fmt.Sprintf("SELECT %s, lower(hex(MD5(%s))) FROM %s%s",
+		strings.Join(selects, ", "), c.chRow(), etl.QuoteIdentifier(c.target), where)
+
+	chRows, err := c.ch.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read clickhouse row hashes: %w", err)
+	}
+	defer chRows.Close()
+
+	var diffs []RowDiff
+	seen := make(map[string]bool)
+	for len(diffs) < limit && chRows.Next() {
+		if err := chRows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to scan clickhouse row hash: %w", err)
+		}
+		id := strings.Join(values[:n], "\x1f")
+		seen[id] = true
+		hash, ok := pgHashes[id]
+		switch {
+		case !ok:
+			diffs = append(diffs, RowDiff{Key: slices.Clone(values[n : 2*n]), Kind: "extra_in_clickhouse"})
+		case hash != values[2*n]:
+			diffs = append(diffs, RowDiff{Key: pgKeys[id], Kind: "different"})
+		}
+	}
+	if err := chRows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read clickhouse row hashes: %w", err)
+	}
+	if len(diffs) >= limit {
+		return diffs, nil
+	}
+
+	for id, key := range pgKeys {
+		if len(diffs) >= limit {
+			break
+		}
+		if !seen[id] {
+			diffs = append(diffs, RowDiff{Key: key, Kind: "missing_in_clickhouse"})
+		}
+	}
+	return diffs, nil
+}
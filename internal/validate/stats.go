@@ -0,0 +1,173 @@
+package validate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"pgtoch/internal/etl"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type metric struct {
+	column string
+	name   string
+	pgExpr string
+	chExpr string
+	absTol float64
+	relTol float64
+}
+
+type ColumnMetric struct {
+	Column     string   `json:"column"`
+	Metric     string   `json:"metric"`
+	Postgres   *float64 `json:"postgres"`
+	ClickHouse *float64 `json:"clickhouse"`
+	Match      bool     `json:"match"`
+}
+
+var numericTypes = map[string]bool{
+	"integer":          true,
+	"bigint":           true,
+	"smallint":         true,
+	"serial":           true,
+	"bigserial":        true,
+	"numeric":          true,
+	"decimal":          true,
+	"double precision": true,
+	"real":             true,
+}
+
+var timestampTypes = map[string]bool{
+	"timestamp":                   true,
+	"timestamp without time zone": true,
+	"timestamp with time zone":    true,
+}
+
+func columnMetrics(cols []Column) []metric {
+	var metrics []metric
+	for _, col := range cols {
+		pg := pgx.Identifier{col.Name}.Sanitize()
+		ch := etl.QuoteIdentifier(col.Target)
+
+		metrics = append(metrics, metric{
+			column: col.Name, name: "null_count",
+			pgExpr: fmt.Sprintf("(count(*) - count(%s))::float8", pg),
+			chExpr: fmt.Sprintf("toFloat64(countIf(isNull(%s)))", ch),
+		})
+
+		switch {
+		case numericTypes[col.Type]:
+			for _, agg := range []string{"min", "max", "sum"} {
+				metrics = append(metrics, metric{
+					column: col.Name, name: agg,
+					pgExpr: fmt.Sprintf("%s(%s)::float8", agg, pg),
+					chExpr: fmt.Sprintf("toFloat64(%s(%s))", agg, ch),
+					relTol: 1e-9,
+				})
+			}
+		case timestampTypes[col.Type]:
+			for _, agg := range []string{"min", "max"} {
+				metrics = append(metrics, metric{
+					column: col.Name, name: agg,
+					pgExpr: fmt.Sprintf("extract(epoch from %s(%s))::float8", agg, pg),
+					chExpr: fmt.Sprintf("toFloat64(toUnixTimestamp(%s(%s)))", agg, ch),
+					absTol: 1,
+				})
+			}
+		case col.Type == "date":
+			for _, agg := range []string{"min", "max"} {
+				metrics = append(metrics, metric{
+					column: col.Name, name: agg,
+					pgExpr: fmt.Sprintf("(%s(%s) - DATE '1970-01-01')::float8", agg, pg),
+					chExpr: fmt.Sprintf("toFloat64(dateDiff('day', toDate('1970-01-01'), %s(%s)))", agg, ch),
+				})
+			}
+		}
+
+		if col.Type != "json" && col.Type != "jsonb" {
+			metrics = append(metrics, metric{
+				column: col.Name, name: "distinct_estimate",
+				pgExpr: fmt.Sprintf("count(DISTINCT %s)::float8", pg),
+				chExpr: fmt.Sprintf("toFloat64(uniq(%s))", ch),
+				relTol: 0.02,
+			})
+		}
+	}
+	return metrics
+}
+
+func compareStats(ctx context.Context, pg etl.Querier, ch *sql.DB, table, target string, cols []Column) ([]ColumnMetric, error) {
+	metrics := columnMetrics(cols)
+	if len(metrics) == 0 {
+		return nil, nil
+	}
+
+	pgExprs := make([]string, len(metrics))
+	chExprs := make([]string, len(metrics))
+	for i, m := range metrics {
+		pgExprs[i] = m.pgExpr
+		chExprs[i] = m.chExpr
+	}
+
+	pgValues := make([]*float64, len(metrics))
+	pgDest := make([]any, len(metrics))
+	for i := range pgValues {
+		pgDest[i] = &pgValues[i]
+	}
+	rows, err := pg.Query(ctx, fmt.Sprintf("SELECT %s FROM %s", strings.Join(pgExprs, ", "), pgx.Identifier{table}.Sanitize()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute postgres aggregates: %w", err)
+	}
+	if rows.Next() {
+		if err := rows.Scan(pgDest...); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan postgres aggregates: %w", err)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to compute postgres aggregates: %w", err)
+	}
+
+	chValues := make([]sql.NullFloat64, len(metrics))
+	chDest := make([]any, len(metrics))
+	for i := range chValues {
+		chDest[i] = &chValues[i]
+	}
+	err = ch.QueryRowContext(ctx, fmt.Sprintf("SELECT %s FROM %s", strings.Join(chExprs, ", "), etl.QuoteIdentifier(target))).Scan(chDest...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute clickhouse aggregates: %w", err)
+	}
+
+	results := make([]ColumnMetric, len(metrics))
+	for i, m := range metrics {
+		var chValue *float64
+		if chValues[i].Valid {
+			v := chValues[i].Float64
+			chValue = &v
+		}
+		results[i] = ColumnMetric{
+			Column:     m.column,
+			Metric:     m.name,
+			Postgres:   pgValues[i],
+			ClickHouse: chValue,
+			Match:      m.matches(pgValues[i], chValue),
+		}
+	}
+	return results, nil
+}
+
+func (m metric) matches(pg, ch *float64) bool {
+	if pg == nil || ch == nil {
+		return pg == nil && (ch == nil || *ch == 0)
+	}
+	diff := math.Abs(*pg - *ch)
+	if diff <= m.absTol {
+		return true
+	}
+	scale := math.Max(math.Abs(*pg), math.Abs(*ch))
+	return scale > 0 && diff/scale <= m.relTol
+}
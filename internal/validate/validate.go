@@ -0,0 +1,217 @@
+package validate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"pgtoch/internal/etl"
+	"pgtoch/internal/log"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+type Options struct {
+	Table string
+	// Target is the ClickHouse table, when it is named differently.
+	Target string
+	// Columns are compared, all columns of Table under their own name when
+	// nil.
+	Columns      []Column
+	Ranges       int
+	RowThreshold int
+	Samples      int
+}
+
+// Column is a Postgres column and the name it is loaded under in ClickHouse.
+type Column struct {
+	etl.Column
+	Target string
+}
+
+type Report struct {
+	Table            string         `json:"table"`
+	PostgresRows     uint64         `json:"postgres_rows"`
+	ClickHouseRows   uint64         `json:"clickhouse_rows"`
+	Columns          []ColumnMetric `json:"columns"`
+	Key              []string       `json:"key,omitempty"`
+	RangesChecked    int            `json:"ranges_checked"`
+	MismatchedRanges []RangeResult  `json:"mismatched_ranges,omitempty"`
+	SampleRows       []RowDiff      `json:"sample_rows,omitempty"`
+	Warnings         []string       `json:"warnings,omitempty"`
+}
+
+func (r *Report) HasDrift() bool {
+	if r.PostgresRows != r.ClickHouseRows || len(r.MismatchedRanges) > 0 {
+		return true
+	}
+	for _, c := range r.Columns {
+		if !c.Match {
+			return true
+		}
+	}
+	return false
+}
+
+func Run(ctx context.Context, pg etl.Querier, ch *sql.DB, opts Options) (*Report, error) {
+	if opts.RowThreshold <= 0 {
+		opts.RowThreshold = 10000
+	}
+	if opts.Samples <= 0 {
+		opts.Samples = 20
+	}
+
+	if opts.Target == "" {
+		opts.Target = opts.Table
+	}
+
+	report := &Report{Table: opts.Table}
+
+	cols := opts.Columns
+	if cols == nil {
+		tableCols, err := etl.TableColumns(ctx, pg, opts.Table)
+		if err != nil {
+			return nil, err
+		}
+		for _, col := range tableCols {
+			cols = append(cols, Column{Column: col, Target: col.Name})
+		}
+	}
+
+	exists, err := etl.TableExists(ctx, ch, opts.Target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check clickhouse table: %w", err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("table %s does not exist in clickhouse", opts.Target)
+	}
+
+	if report.PostgresRows, err = pgCount(ctx, pg, opts.Table); err != nil {
+		return nil, err
+	}
+	if report.ClickHouseRows, err = etl.CountRows(ctx, ch, opts.Target); err != nil {
+		return nil, err
+	}
+
+	log.Logger.Info("Comparing column aggregates", zap.String("table", opts.Table), zap.Int("columns", len(cols)))
+	if report.Columns, err = compareStats(ctx, pg, ch, opts.Table, opts.Target, cols); err != nil {
+		return nil, err
+	}
+
+	if opts.Ranges <= 0 {
+		return report, nil
+	}
+
+	key, err := etl.PrimaryKey(ctx, pg, opts.Table)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) == 0 {
+		report.Warnings = append(report.Warnings, "table has no primary key; range checksums skipped")
+		return report, nil
+	}
+	report.Key = key
+	for _, k := range key {
+		if !slices.ContainsFunc(cols, func(c Column) bool { return c.Name == k }) {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("key column %s is not compared; range checksums skipped", k))
+			return report, nil
+		}
+	}
+
+	c, skipped := newChecker(pg, ch, opts.Table, opts.Target, cols, key)
+	for _, k := range key {
+		if slices.Contains(skipped, k) {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("key column %s cannot be hashed; range checksums skipped", k))
+			return report, nil
+		}
+	}
+	if len(skipped) > 0 {
+		report.Warnings = append(report.Warnings, "columns left out of range checksums: "+strings.Join(skipped, ", "))
+	}
+	bounds, err := c.boundaries(ctx, opts.Ranges)
+	if err != nil {
+		return nil, err
+	}
+
+	var ranges []keyRange
+	var after []string
+	for i, b := range bounds {
+		upTo := b
+		if i == len(bounds)-1 {
+			upTo = nil
+		}
+		ranges = append(ranges, keyRange{after: after, upTo: upTo})
+		after = b
+	}
+	if len(ranges) == 0 {
+		ranges = append(ranges, keyRange{})
+	}
+
+	log.Logger.Info("Comparing range checksums", zap.String("table", opts.Table), zap.Int("ranges", len(ranges)))
+	for _, r := range ranges {
+		if err := report.narrow(ctx, c, r, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+func (report *Report) narrow(ctx context.Context, c *checker, r keyRange, opts Options) error {
+	report.RangesChecked++
+
+	pgSum, chSum, err := c.checksums(ctx, r)
+	if err != nil {
+		return err
+	}
+	if pgSum == chSum {
+		return nil
+	}
+
+	if pgSum.rows > uint64(opts.RowThreshold) {
+		mid, err := c.median(ctx, r, pgSum.rows)
+		if err != nil {
+			return err
+		}
+		if mid != nil {
+			if err := report.narrow(ctx, c, keyRange{after: r.after, upTo: mid}, opts); err != nil {
+				return err
+			}
+			return report.narrow(ctx, c, keyRange{after: mid, upTo: r.upTo}, opts)
+		}
+	}
+
+	report.MismatchedRanges = append(report.MismatchedRanges, RangeResult{
+		After:          r.after,
+		UpTo:           r.upTo,
+		PostgresRows:   pgSum.rows,
+		ClickHouseRows: chSum.rows,
+	})
+
+	if remaining := opts.Samples - len(report.SampleRows); remaining > 0 {
+		diffs, err := c.rowDiffs(ctx, r, remaining)
+		if err != nil {
+			return err
+		}
+		report.SampleRows = append(report.SampleRows, diffs...)
+	}
+	return nil
+}
+
+func pgCount(ctx context.Context, pg etl.Querier, table string) (uint64, error) {
+	rows, err := pg.Query(ctx, "SELECT count(*) FROM "+pgx.Identifier{table}.Sanitize())
+	if err != nil {
+		return 0, fmt.Errorf("failed to count postgres rows: %w", err)
+	}
+	defer rows.Close()
+
+	var count int64
+	if rows.Next() {
+		if err := rows.Scan(&count); err != nil {
+			return 0, fmt.Errorf("failed to scan postgres row count: %w", err)
+		}
+	}
+	return uint64(count), rows.Err()
+}